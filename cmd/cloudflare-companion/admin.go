@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	toggleDocker     = "docker"
+	toggleTraefik    = "traefik"
+	toggleCloudflare = "cloudflare"
+)
+
+type Toggles struct {
+	dockerPaused     atomic.Bool
+	traefikPaused    atomic.Bool
+	cloudflarePaused atomic.Bool
+}
+
+func (t *Toggles) flag(name string) *atomic.Bool {
+	switch name {
+	case toggleDocker:
+		return &t.dockerPaused
+	case toggleTraefik:
+		return &t.traefikPaused
+	case toggleCloudflare:
+		return &t.cloudflarePaused
+	}
+	return nil
+}
+
+func (t *Toggles) Paused(name string) bool {
+	flag := t.flag(name)
+	return flag != nil && flag.Load()
+}
+
+func (t *Toggles) SetPaused(name string, paused bool) bool {
+	flag := t.flag(name)
+	if flag == nil {
+		return false
+	}
+	flag.Store(paused)
+	return true
+}
+
+func (t *Toggles) State() map[string]bool {
+	return map[string]bool{
+		toggleDocker:     !t.Paused(toggleDocker),
+		toggleTraefik:    !t.Paused(toggleTraefik),
+		toggleCloudflare: !t.Paused(toggleCloudflare),
+	}
+}
+
+func (c *Companion) adminHandler(logger *Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /toggles", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, c.toggles.State())
+	})
+	mux.HandleFunc("POST /toggles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "enabled query parameter must be true or false", http.StatusBadRequest)
+			return
+		}
+		name := r.PathValue("name")
+		if !c.toggles.SetPaused(name, !enabled) {
+			http.Error(w, "unknown toggle "+name, http.StatusNotFound)
+			return
+		}
+		logger.Infof("Runtime toggle %s enabled=%v", name, enabled)
+		writeJSON(w, http.StatusOK, c.toggles.State())
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, buildInfo())
+	})
+	mux.HandleFunc("GET /state", func(w http.ResponseWriter, _ *http.Request) {
+		c.syncedM.Lock()
+		state := maps.Clone(c.synced)
+		c.syncedM.Unlock()
+		writeJSON(w, http.StatusOK, state)
+	})
+	mux.HandleFunc("GET /domains", func(w http.ResponseWriter, _ *http.Request) {
+		c.domainMatchesM.Lock()
+		matches := maps.Clone(c.domainMatches)
+		c.domainMatchesM.Unlock()
+		writeJSON(w, http.StatusOK, matches)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := c.metrics.Write(w); err != nil {
+			logger.Debugf("failed to write metrics: %v", err)
+		}
+	})
+	return requireBearerToken(c.cfg.AdminToken, mux)
+}
+
+// requireBearerToken rejects requests without the given bearer token; an
+// empty token leaves the handler unprotected.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Companion) RunAdminServer(ctx context.Context, logger *Logger) {
+	srv := &http.Server{
+		Addr:              c.cfg.AdminListen,
+		Handler:           c.adminHandler(logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logger.Infof("Admin server listening on %s", c.cfg.AdminListen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorf("admin server failed: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminToggles(t *testing.T) {
+	comp := &Companion{}
+	handler := comp.adminHandler(NewLogger("ERROR"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/toggles/cloudflare?enabled=false", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"docker":true,"traefik":true,"cloudflare":false}`, rec.Body.String())
+	require.True(t, comp.toggles.Paused(toggleCloudflare))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/toggles/unknown?enabled=false", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/toggles/docker?enabled=maybe", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAdminState(t *testing.T) {
+	comp := &Companion{
+		cfg:    Config{AdminToken: "secret"},
+		synced: map[string]int{"a.example.com": 1, "b.example.com": 2},
+	}
+	handler := comp.adminHandler(NewLogger("ERROR"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/state", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/state", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"a.example.com":1,"b.example.com":2}`, rec.Body.String())
+}
+
+func TestAdminVersion(t *testing.T) {
+	comp := &Companion{}
+	rec := httptest.NewRecorder()
+	comp.adminHandler(NewLogger("ERROR")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"version":"dev","commit":"unknown","date":"unknown"}`, rec.Body.String())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// configSummary renders the parsed configuration for CHECK_CONFIG, with
+// secrets redacted.
+func configSummary(cfg Config) string {
+	var b strings.Builder
+	line := func(name string, value any) {
+		fmt.Fprintf(&b, "  %-24s %v\n", name+":", value)
+	}
+
+	b.WriteString("Cloudflare:\n")
+	if cfg.CloudflareEmail != "" {
+		line("Auth", "global API key")
+		line("Email", cfg.CloudflareEmail)
+	} else {
+		line("Auth", "API token")
+	}
+	line("Token", redact(cfg.CloudflareToken))
+	if cfg.CloudflareTokenFile != "" {
+		line("Token file", cfg.CloudflareTokenFile)
+	}
+	line("API", cloudflareAPIURL(cfg.CloudflareAPIBase, cfg.CloudflareAPIVersion))
+	line("Record type", cfg.RecordType)
+	line("Target", cfg.TargetDomain)
+	if cfg.TargetTemplate != "" {
+		line("Target template", cfg.TargetTemplate)
+	}
+	line("Default TTL", formatTTL(cfg.DefaultTTL))
+	line("Custom hostnames", cfg.CustomHostnames)
+	line("Dry run", cfg.DryRun)
+
+	b.WriteString("Domains:\n")
+	for _, dom := range cfg.Domains {
+		fmt.Fprintf(&b, "  %s\n", dom.Name)
+		fmt.Fprintf(&b, "    zone=%s type=%s target=%s ttl=%s proxied=%v\n",
+			dom.ZoneID, dom.RecordType, dom.TargetDomain, formatTTL(dom.TTL), dom.Proxied)
+		if dom.CloudflareToken != "" {
+			fmt.Fprintf(&b, "    token=%s\n", redact(dom.CloudflareToken))
+		}
+		if excluded := excludedSubDomainEntries(dom); len(excluded) > 0 {
+			fmt.Fprintf(&b, "    excluded sub domains=%s\n", strings.Join(excluded, ", "))
+		}
+		if len(dom.IncludedHosts) > 0 || len(dom.ExcludedHosts) > 0 {
+			fmt.Fprintf(&b, "    included hosts=%s excluded hosts=%s\n", patterns(dom.IncludedHosts), patterns(dom.ExcludedHosts))
+		}
+	}
+	line("Match mode", cfg.DomainMatchMode)
+
+	b.WriteString("Discovery:\n")
+	line("Docker poll", cfg.EnableDockerPoll)
+	line("Swarm mode", cfg.DockerSwarmMode)
+	line("Traefik poll", cfg.EnableTraefikPoll)
+	if cfg.EnableTraefikPoll {
+		line("Traefik API", traefikAPIURL(cfg.TraefikPollURL, cfg.TraefikAPIPath))
+		if socket := traefikSocket(cfg.TraefikPollURL); socket != "" {
+			line("Traefik socket", socket)
+		}
+		line("Traefik poll seconds", fmt.Sprintf("%d (%d-%d)", cfg.TraefikPollSecs, cfg.TraefikPollMinSecs, cfg.TraefikPollMaxSecs))
+	}
+	line("Traefik version", cfg.TraefikVersion)
+	line("Included hosts", patterns(cfg.IncludedHosts))
+	line("Excluded hosts", patterns(cfg.ExcludedHosts))
+
+	if cfg.AdminListen != "" || cfg.WebhookURL != "" {
+		b.WriteString("Integrations:\n")
+		if cfg.AdminListen != "" {
+			line("Admin listen", cfg.AdminListen)
+			line("Admin token", redact(cfg.AdminToken))
+		}
+		if cfg.WebhookURL != "" {
+			line("Webhook", redact(cfg.WebhookURL))
+		}
+	}
+	return b.String()
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "(not set)"
+	}
+	return "(set)"
+}
+
+func patterns(rxs []*regexp.Regexp) string {
+	if len(rxs) == 0 {
+		return "(none)"
+	}
+	out := make([]string, 0, len(rxs))
+	for _, rx := range rxs {
+		out = append(out, rx.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// excludedSubDomainEntries lists the excluded sub domains as configured, with
+// the regexes prefixed with re: again.
+func excludedSubDomainEntries(dom DomainConfig) []string {
+	entries := slices.Clone(dom.ExcludedSubDomains)
+	for _, re := range dom.ExcludedSubDomainRegexes {
+		entries = append(entries, excludedSubDomainRegexPrefix+re.String())
+	}
+	return entries
+}
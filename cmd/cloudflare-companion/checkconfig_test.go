@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSummaryRedactsSecrets(t *testing.T) {
+	t.Setenv("CF_TOKEN", "super-secret-token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_TTL", "300")
+	t.Setenv("DOMAIN1_CF_TOKEN", "second-secret-token")
+	t.Setenv("DOMAIN1_EXCLUDED_SUB_DOMAINS", `lan, re:-internal\.`)
+	t.Setenv("ADMIN_LISTEN", "127.0.0.1:8081")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	summary := configSummary(cfg)
+
+	require.Contains(t, summary, "  example.com\n    zone=zone1 type=CNAME target=lb.example.net ttl=300 proxied=false\n    token=(set)\n    excluded sub domains=lan, re:-internal\\.\n")
+	require.Contains(t, summary, "  Token:                   (set)\n")
+	require.Contains(t, summary, "  Default TTL:             auto\n")
+	require.Contains(t, summary, "  Admin token:             (set)\n")
+	for _, secret := range []string{"super-secret-token", "second-secret-token", "admin-secret"} {
+		require.NotContains(t, summary, secret)
+	}
+}
+
+func TestCheckConfigRejectsInvalidTTL(t *testing.T) {
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_TTL", "5")
+
+	_, err := LoadConfigFromEnv()
+	require.EqualError(t, err, "DOMAIN1_TTL: TTL 5 must be auto or between 30 and 86400 seconds")
+
+	t.Setenv("DOMAIN1_TTL", "")
+	t.Setenv("DEFAULT_TTL", "100000")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, "DEFAULT_TTL: TTL 100000 must be auto or between 30 and 86400 seconds")
+}
@@ -2,60 +2,243 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
+const (
+	cloudflareAPIBase    = "https://api.cloudflare.com/client"
+	cloudflareAPIVersion = "v4"
+
+	defaultCFMaxIdleConnsPerHost = 10
+	defaultCFKeepAlive           = 30 * time.Second
+)
+
+// defaultCFRequestTimeout bounds each Cloudflare request until
+// SetRequestTimeout changes it. It is the only timeout of the client, so a
+// longer CF_REQUEST_TIMEOUT_SECONDS is not cut short.
+var defaultCFRequestTimeout = 20 * time.Second
+
 type CloudflareAPI struct {
 	httpClient *http.Client
+	baseURL    string
 	email      string
 	token      string
+	tokenFile  string
+	tokenMu    sync.RWMutex
 	logger     *Logger
+
+	requestTimeout time.Duration
+	limiter        *rate.Limiter
+	metrics        *requestMetrics
+	userAgent      string
+
+	createRaceJitter time.Duration
 }
 
 type DNSRecord struct {
-	ID      string `json:"id"`
-	Content string `json:"content"`
+	ID       string   `json:"id"`
+	Type     string   `json:"type"`
+	Content  string   `json:"content"`
+	TTL      int      `json:"ttl"`
+	Proxied  bool     `json:"proxied"`
+	Comment  string   `json:"comment"`
+	Tags     []string `json:"tags"`
+	Priority *int     `json:"priority"`
+}
+
+// sameContent compares record contents, ignoring the quotes Cloudflare may
+// add around TXT content.
+func sameContent(recordType string, a string, b string) bool {
+	if strings.EqualFold(recordType, "TXT") {
+		return strings.Trim(a, `"`) == strings.Trim(b, `"`)
+	}
+	return a == b
+}
+
+// recordMatches reports whether an existing record already has every field
+// the request would write, so updating it would be a no-op. The TTL of
+// proxied records is ignored, as Cloudflare always reports them as automatic.
+func recordMatches(rec DNSRecord, req DNSRecordRequest) bool {
+	return strings.EqualFold(rec.Type, req.Type) &&
+		sameContent(req.Type, rec.Content, req.Content) &&
+		(req.Priority == nil || rec.Priority != nil && *rec.Priority == *req.Priority) &&
+		(req.Proxied || rec.TTL == req.TTL) &&
+		rec.Proxied == req.Proxied &&
+		rec.Comment == req.Comment &&
+		slices.Equal(slices.Sorted(slices.Values(rec.Tags)), slices.Sorted(slices.Values(req.Tags)))
 }
 
 type DNSRecordRequest struct {
-	Type    string `json:"type"`
-	Name    string `json:"name"`
-	Content string `json:"content"`
-	TTL     int    `json:"ttl"`
-	Proxied bool   `json:"proxied"`
-	Comment string `json:"comment,omitempty"`
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Content  string   `json:"content"`
+	TTL      int      `json:"ttl"`
+	Proxied  bool     `json:"proxied"`
+	Comment  string   `json:"comment,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Priority *int     `json:"priority,omitempty"`
+	// Data holds the structured fields of record types such as SRV, from
+	// which Cloudflare derives the content.
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// String formats the request like %+v, with the automatic TTL shown as
+// "auto".
+func (r DNSRecordRequest) String() string {
+	priority := ""
+	if r.Priority != nil {
+		priority = fmt.Sprintf(" Priority:%d", *r.Priority)
+	}
+	return fmt.Sprintf("{Type:%s Name:%s Content:%s TTL:%s Proxied:%v Comment:%s Tags:%v%s}",
+		r.Type, r.Name, r.Content, formatTTL(r.TTL), r.Proxied, r.Comment, r.Tags, priority)
+}
+
+// ttlAuto is the TTL value Cloudflare treats as automatic.
+const ttlAuto = 1
+
+func formatTTL(ttl int) string {
+	if ttl == ttlAuto {
+		return "auto"
+	}
+	return strconv.Itoa(ttl)
+}
+
+const (
+	cfErrHostRecordExists    = 81053
+	cfErrRecordAlreadyExists = 81057
+)
+
+// defaultCreateRaceJitter bounds the random wait before looking up a record
+// created concurrently, giving the racing create time to become visible.
+const defaultCreateRaceJitter = 500 * time.Millisecond
+
+type CloudflareErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type CloudflareError struct {
+	Op         string
+	StatusCode int
+	Body       string
+	Errors     []CloudflareErrorDetail
+}
+
+func (e *CloudflareError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Body)
+	}
+	parts := make([]string, 0, len(e.Errors))
+	for _, detail := range e.Errors {
+		parts = append(parts, fmt.Sprintf("%d %s", detail.Code, detail.Message))
+	}
+	return fmt.Sprintf("cloudflare %s failed: %s", e.Op, strings.Join(parts, "; "))
+}
+
+func (e *CloudflareError) HasCode(code int) bool {
+	for _, detail := range e.Errors {
+		if detail.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordConflictError is returned by CreateDNSRecord when Cloudflare rejects
+// the create because the name already has records, none identical to the
+// one created. Records holds the ones listed, sparing the caller a lookup.
+type RecordConflictError struct {
+	*CloudflareError
+	Records []DNSRecord
+}
+
+func (e *RecordConflictError) Unwrap() error {
+	return e.CloudflareError
 }
 
 type cfResponse[T any] struct {
-	Success bool `json:"success"`
-	Errors  []struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-	} `json:"errors"`
-	Result T `json:"result"`
+	Success bool                    `json:"success"`
+	Errors  []CloudflareErrorDetail `json:"errors"`
+	Result  T                       `json:"result"`
 }
 
-func NewCloudflareAPI(email string, token string, logger *Logger) (*CloudflareAPI, error) {
+func cloudflareAPIURL(base string, version string) string {
+	base = strings.TrimRight(defaultString(base, cloudflareAPIBase), "/")
+	version = strings.Trim(defaultString(version, cloudflareAPIVersion), "/")
+	return base + "/" + version
+}
+
+func NewCloudflareAPI(email string, token string, apiURL string, logger *Logger) (*CloudflareAPI, error) {
 	if strings.TrimSpace(token) == "" {
 		return nil, fmt.Errorf("missing token")
 	}
 	return &CloudflareAPI{
-		httpClient: &http.Client{Timeout: 20 * time.Second},
-		email:      strings.TrimSpace(email),
-		token:      strings.TrimSpace(token),
-		logger:     logger,
+		httpClient: &http.Client{
+			Transport: newCloudflareTransport(defaultCFMaxIdleConnsPerHost, defaultCFKeepAlive),
+		},
+		baseURL: strings.TrimRight(apiURL, "/"),
+		email:   strings.TrimSpace(email),
+		token:   strings.TrimSpace(token),
+		logger:  logger,
+
+		requestTimeout: defaultCFRequestTimeout,
+
+		createRaceJitter: defaultCreateRaceJitter,
 	}, nil
 }
 
-func (cf *CloudflareAPI) ListDNSRecords(zoneID string, name string) ([]DNSRecord, error) {
-	path := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s", zoneID, url.QueryEscape(name))
-	body, err := cf.doRequest(http.MethodGet, path, nil)
+// newCloudflareTransport returns a pooled transport that honors
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+func newCloudflareTransport(maxIdleConnsPerHost int, keepAlive time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, maxIdleConnsPerHost)
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: keepAlive,
+	}).DialContext
+	transport.DisableKeepAlives = keepAlive < 0
+	return transport
+}
+
+func (cf *CloudflareAPI) SetMetrics(metrics *requestMetrics) {
+	cf.metrics = metrics
+}
+
+// SetRateLimit paces requests to perMinute, waiting for a free slot instead
+// of failing. Zero or less disables the limit.
+func (cf *CloudflareAPI) SetRateLimit(perMinute int) {
+	if perMinute <= 0 {
+		cf.limiter = nil
+		return
+	}
+	cf.limiter = rate.NewLimiter(rate.Limit(float64(perMinute)/60), 1)
+}
+
+func (cf *CloudflareAPI) SetTransport(transport http.RoundTripper) {
+	cf.httpClient.Transport = transport
+}
+
+func (cf *CloudflareAPI) ListDNSRecords(ctx context.Context, zoneID string, name string) ([]DNSRecord, error) {
+	path := fmt.Sprintf("%s/zones/%s/dns_records?name=%s", cf.baseURL, zoneID, url.QueryEscape(name))
+	body, err := cf.doRequest(ctx, "list", http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -64,18 +247,141 @@ func (cf *CloudflareAPI) ListDNSRecords(zoneID string, name string) ([]DNSRecord
 		return nil, err
 	}
 	if !parsed.Success {
-		return nil, fmt.Errorf("cloudflare list failed: %s", cf.formatErrors(parsed.Errors))
+		return nil, &CloudflareError{Op: "list", Errors: parsed.Errors}
+	}
+	return parsed.Result, nil
+}
+
+// CreateDNSRecord creates a record and returns it. Some gateways answer with
+// success but an empty result, in which case the ID is looked up by listing.
+// A create losing a race to an identical record returns that record, one
+// conflicting with other records fails with a RecordConflictError.
+func (cf *CloudflareAPI) CreateDNSRecord(ctx context.Context, zoneID string, record DNSRecordRequest) (DNSRecord, error) {
+	path := fmt.Sprintf("%s/zones/%s/dns_records", cf.baseURL, zoneID)
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	body, err := cf.doRequest(ctx, "create", http.MethodPost, path, payload)
+	if err != nil {
+		return cf.createdConcurrently(ctx, zoneID, record, err)
+	}
+	var parsed cfResponse[DNSRecord]
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return DNSRecord{}, err
+	}
+	if !parsed.Success {
+		return cf.createdConcurrently(ctx, zoneID, record, &CloudflareError{Op: "create", Errors: parsed.Errors})
+	}
+	if parsed.Result.ID != "" {
+		return parsed.Result, nil
+	}
+
+	cf.logger.ForContext(ctx).Verbosef("Cloudflare create of %s returned no record, looking it up", record.Name)
+	records, err := cf.ListDNSRecords(ctx, zoneID, record.Name)
+	if err != nil {
+		return DNSRecord{}, fmt.Errorf("record created but lookup failed: %w", err)
+	}
+	for _, rec := range records {
+		if rec.Type == record.Type && sameContent(record.Type, rec.Content, record.Content) {
+			return rec, nil
+		}
+	}
+	return DNSRecord{}, nil
+}
+
+// createdConcurrently handles the create error err. When Cloudflare rejected
+// the create with 81053 or 81057 because another writer created records for
+// the same name while ours was in flight, it lists them and returns the one
+// identical in type and content, or a RecordConflictError holding them all.
+// Any other error, or a failed lookup, is returned as is.
+func (cf *CloudflareAPI) createdConcurrently(ctx context.Context, zoneID string, record DNSRecordRequest, err error) (DNSRecord, error) {
+	var cfErr *CloudflareError
+	if !errors.As(err, &cfErr) || (!cfErr.HasCode(cfErrHostRecordExists) && !cfErr.HasCode(cfErrRecordAlreadyExists)) {
+		return DNSRecord{}, err
+	}
+	if cf.createRaceJitter > 0 {
+		timer := time.NewTimer(rand.N(cf.createRaceJitter))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return DNSRecord{}, err
+		case <-timer.C:
+		}
+	}
+	records, listErr := cf.ListDNSRecords(ctx, zoneID, record.Name)
+	if listErr != nil {
+		cf.logger.ForContext(ctx).Verbosef("Cloudflare create of %s conflicted and the lookup failed: %v", record.Name, listErr)
+		return DNSRecord{}, err
+	}
+	for _, rec := range records {
+		if rec.Type == record.Type && sameContent(record.Type, rec.Content, record.Content) {
+			cf.logger.ForContext(ctx).Verbosef("Cloudflare create of %s conflicted with an identical record %s created concurrently, using it", record.Name, rec.ID)
+			return rec, nil
+		}
+	}
+	return DNSRecord{}, &RecordConflictError{CloudflareError: cfErr, Records: records}
+}
+
+type CustomHostname struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	Status   string `json:"status"`
+}
+
+type CustomHostnameSSL struct {
+	Method string `json:"method"`
+	Type   string `json:"type"`
+}
+
+type CustomHostnameRequest struct {
+	Hostname string            `json:"hostname"`
+	SSL      CustomHostnameSSL `json:"ssl"`
+}
+
+func (cf *CloudflareAPI) ListCustomHostnames(ctx context.Context, zoneID string, hostname string) ([]CustomHostname, error) {
+	path := fmt.Sprintf("%s/zones/%s/custom_hostnames?hostname=%s", cf.baseURL, zoneID, url.QueryEscape(hostname))
+	body, err := cf.doRequest(ctx, "list custom hostnames", http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var parsed cfResponse[[]CustomHostname]
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if !parsed.Success {
+		return nil, &CloudflareError{Op: "list custom hostnames", Errors: parsed.Errors}
 	}
 	return parsed.Result, nil
 }
 
-func (cf *CloudflareAPI) CreateDNSRecord(zoneID string, record DNSRecordRequest) error {
-	path := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", zoneID)
+func (cf *CloudflareAPI) CreateCustomHostname(ctx context.Context, zoneID string, hostname CustomHostnameRequest) (CustomHostname, error) {
+	path := fmt.Sprintf("%s/zones/%s/custom_hostnames", cf.baseURL, zoneID)
+	payload, err := json.Marshal(hostname)
+	if err != nil {
+		return CustomHostname{}, err
+	}
+	body, err := cf.doRequest(ctx, "create custom hostname", http.MethodPost, path, payload)
+	if err != nil {
+		return CustomHostname{}, err
+	}
+	var parsed cfResponse[CustomHostname]
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return CustomHostname{}, err
+	}
+	if !parsed.Success {
+		return CustomHostname{}, &CloudflareError{Op: "create custom hostname", Errors: parsed.Errors}
+	}
+	return parsed.Result, nil
+}
+
+func (cf *CloudflareAPI) UpdateDNSRecord(ctx context.Context, zoneID string, recordID string, record DNSRecordRequest) error {
+	path := fmt.Sprintf("%s/zones/%s/dns_records/%s", cf.baseURL, zoneID, recordID)
 	payload, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
-	body, err := cf.doRequest(http.MethodPost, path, payload)
+	body, err := cf.doRequest(ctx, "update", http.MethodPut, path, payload)
 	if err != nil {
 		return err
 	}
@@ -84,51 +390,154 @@ func (cf *CloudflareAPI) CreateDNSRecord(zoneID string, record DNSRecordRequest)
 		return err
 	}
 	if !parsed.Success {
-		return fmt.Errorf("cloudflare create failed: %s", cf.formatErrors(parsed.Errors))
+		return &CloudflareError{Op: "update", Errors: parsed.Errors}
 	}
 	return nil
 }
 
-func (cf *CloudflareAPI) UpdateDNSRecord(zoneID string, recordID string, record DNSRecordRequest) error {
-	path := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", zoneID, recordID)
-	payload, err := json.Marshal(record)
+func (cf *CloudflareAPI) DeleteDNSRecord(ctx context.Context, zoneID string, recordID string) error {
+	path := fmt.Sprintf("%s/zones/%s/dns_records/%s", cf.baseURL, zoneID, recordID)
+	body, err := cf.doRequest(ctx, "delete", http.MethodDelete, path, nil)
 	if err != nil {
 		return err
 	}
-	body, err := cf.doRequest(http.MethodPut, path, payload)
+	var parsed cfResponse[DNSRecord]
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return err
+	}
+	if !parsed.Success {
+		return &CloudflareError{Op: "delete", Errors: parsed.Errors}
+	}
+	return nil
+}
+
+func (cf *CloudflareAPI) GetDNSSECStatus(ctx context.Context, zoneID string) (string, error) {
+	path := fmt.Sprintf("%s/zones/%s/dnssec", cf.baseURL, zoneID)
+	body, err := cf.doRequest(ctx, "dnssec", http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	var parsed cfResponse[struct {
+		Status string `json:"status"`
+	}]
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", err
+	}
+	if !parsed.Success {
+		return "", &CloudflareError{Op: "dnssec", Errors: parsed.Errors}
+	}
+	return parsed.Result.Status, nil
+}
+
+// VerifyToken checks that the API token is valid and active. It only applies to
+// token auth, legacy email/key credentials cannot be verified this way.
+func (cf *CloudflareAPI) VerifyToken(ctx context.Context) error {
+	body, err := cf.doRequest(ctx, "token verify", http.MethodGet, cf.baseURL+"/user/tokens/verify", nil)
 	if err != nil {
 		return err
 	}
-	var parsed cfResponse[DNSRecord]
+	var parsed cfResponse[struct {
+		Status string `json:"status"`
+	}]
 	if err := json.Unmarshal(body, &parsed); err != nil {
 		return err
 	}
 	if !parsed.Success {
-		return fmt.Errorf("cloudflare update failed: %s", cf.formatErrors(parsed.Errors))
+		return &CloudflareError{Op: "token verify", Errors: parsed.Errors}
+	}
+	if parsed.Result.Status != "active" {
+		return fmt.Errorf("token status is %q", parsed.Result.Status)
 	}
 	return nil
 }
 
-func (cf *CloudflareAPI) doRequest(method string, endpoint string, body []byte) ([]byte, error) {
-	cf.logger.Verbosef("Querying Cloudflare API: %s %s", method, endpoint)
+func (cf *CloudflareAPI) SetRequestTimeout(timeout time.Duration) {
+	cf.requestTimeout = timeout
+}
+
+func (cf *CloudflareAPI) SetUserAgent(userAgent string) {
+	cf.userAgent = userAgent
+}
+
+func (cf *CloudflareAPI) SetTokenFile(path string) {
+	cf.tokenMu.Lock()
+	defer cf.tokenMu.Unlock()
+	cf.tokenFile = path
+}
+
+func (cf *CloudflareAPI) currentToken() string {
+	cf.tokenMu.RLock()
+	defer cf.tokenMu.RUnlock()
+	return cf.token
+}
+
+// reloadToken re-reads the token file the token was resolved from and
+// reports whether a different token was found, e.g. after an external rotation.
+func (cf *CloudflareAPI) reloadToken(ctx context.Context) bool {
+	cf.tokenMu.Lock()
+	defer cf.tokenMu.Unlock()
+	if cf.tokenFile == "" {
+		return false
+	}
+	contents, err := os.ReadFile(cf.tokenFile)
+	if err != nil {
+		cf.logger.ForContext(ctx).Errorf("failed to re-read cloudflare token file %s: %v", cf.tokenFile, err)
+		return false
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" || token == cf.token {
+		return false
+	}
+	cf.token = token
+	return true
+}
+
+func (cf *CloudflareAPI) doRequest(ctx context.Context, op string, method string, endpoint string, body []byte) ([]byte, error) {
+	respBytes, err := cf.send(ctx, op, method, endpoint, body)
+	var cfErr *CloudflareError
+	if errors.As(err, &cfErr) && (cfErr.StatusCode == http.StatusUnauthorized || cfErr.StatusCode == http.StatusForbidden) && cf.reloadToken(ctx) {
+		cf.logger.ForContext(ctx).Infof("Reloaded Cloudflare token from %s, retrying %s %s", cf.tokenFile, method, endpoint)
+		return cf.send(ctx, op, method, endpoint, body)
+	}
+	return respBytes, err
+}
+
+func (cf *CloudflareAPI) send(ctx context.Context, op string, method string, endpoint string, body []byte) ([]byte, error) {
+	if cf.limiter != nil {
+		if err := cf.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	cf.logger.ForContext(ctx).Verbosef("Querying Cloudflare API: %s %s", method, endpoint)
+	if cf.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cf.requestTimeout)
+		defer cancel()
+	}
 	var reader io.Reader
 	if body != nil {
 		reader = bytes.NewReader(body)
 	}
-	req, err := http.NewRequest(method, endpoint, reader)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if cf.userAgent != "" {
+		req.Header.Set("User-Agent", cf.userAgent)
+	}
+	token := cf.currentToken()
 	if cf.email != "" {
 		req.Header.Set("X-Auth-Email", cf.email)
-		req.Header.Set("X-Auth-Key", cf.token)
+		req.Header.Set("X-Auth-Key", token)
 	} else {
-		req.Header.Set("Authorization", "Bearer "+cf.token)
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
+	start := time.Now()
 	resp, err := cf.httpClient.Do(req)
 	if err != nil {
+		cf.metrics.Observe(op, requestResultError, time.Since(start))
 		return nil, err
 	}
 	defer func() {
@@ -137,22 +546,18 @@ func (cf *CloudflareAPI) doRequest(method string, endpoint string, body []byte)
 
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
+		cf.metrics.Observe(op, requestResultError, time.Since(start))
 		return nil, err
 	}
+	cf.metrics.Observe(op, requestResult(resp.StatusCode), time.Since(start))
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("http status %d: %s", resp.StatusCode, string(respBytes))
+		cfErr := &CloudflareError{StatusCode: resp.StatusCode, Body: string(respBytes)}
+		var parsed cfResponse[json.RawMessage]
+		if json.Unmarshal(respBytes, &parsed) == nil {
+			cfErr.Errors = parsed.Errors
+		}
+		return nil, cfErr
 	}
-	cf.logger.Verbosef("Cloudflare API response: %s %s -> %d", method, endpoint, resp.StatusCode)
+	cf.logger.ForContext(ctx).Verbosef("Cloudflare API response: %s %s -> %d", method, endpoint, resp.StatusCode)
 	return respBytes, nil
 }
-
-func (cf *CloudflareAPI) formatErrors(errors []struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}) string {
-	parts := make([]string, 0, len(errors))
-	for _, e := range errors {
-		parts = append(parts, fmt.Sprintf("%d %s", e.Code, e.Message))
-	}
-	return strings.Join(parts, "; ")
-}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCloudflare(t *testing.T, handler http.HandlerFunc) *CloudflareAPI {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	cf, err := NewCloudflareAPI("", "token", ts.URL, NewLogger("ERROR"))
+	require.NoError(t, err)
+	cf.createRaceJitter = 0
+	return cf
+}
+
+func TestCloudflareErrorFormatting(t *testing.T) {
+	err := &CloudflareError{Op: "create", Errors: []CloudflareErrorDetail{
+		{Code: cfErrRecordAlreadyExists, Message: "An identical record already exists."},
+		{Code: 1004, Message: "DNS Validation Error"},
+	}}
+	require.Equal(t, "cloudflare create failed: 81057 An identical record already exists.; 1004 DNS Validation Error", err.Error())
+	require.True(t, err.HasCode(cfErrRecordAlreadyExists))
+	require.False(t, err.HasCode(9109))
+
+	httpErr := &CloudflareError{StatusCode: 403, Body: "forbidden"}
+	require.Equal(t, "http status 403: forbidden", httpErr.Error())
+}
+
+func TestCloudflareAPIURL(t *testing.T) {
+	require.Equal(t, "https://api.cloudflare.com/client/v4", cloudflareAPIURL("", ""))
+	require.Equal(t, "https://gw.example.com/cf/v5", cloudflareAPIURL("https://gw.example.com/cf/", "/v5/"))
+}
+
+func TestCloudflareCustomAPIVersion(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	}))
+	defer ts.Close()
+
+	cf, err := NewCloudflareAPI("", "token", cloudflareAPIURL(ts.URL+"/client", "v5"), NewLogger("ERROR"))
+	require.NoError(t, err)
+	_, err = cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/client/v5/zones/zone/dns_records"}, paths)
+}
+
+func TestDeleteDNSRecord(t *testing.T) {
+	var method, path string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+	})
+
+	require.NoError(t, cf.DeleteDNSRecord(context.Background(), "zone", "rec"))
+	require.Equal(t, http.MethodDelete, method)
+	require.Equal(t, "/zones/zone/dns_records/rec", path)
+}
+
+func TestDeleteDNSRecordFailure(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":81044,"message":"Record does not exist."}]}`))
+	})
+
+	err := cf.DeleteDNSRecord(context.Background(), "zone", "rec")
+	var cfErr *CloudflareError
+	require.ErrorAs(t, err, &cfErr)
+	require.True(t, cfErr.HasCode(81044))
+	require.Equal(t, "cloudflare delete failed: 81044 Record does not exist.", err.Error())
+}
+
+func TestDNSRecordRequestTagsSerialization(t *testing.T) {
+	var body []byte
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+	})
+
+	_, err := cf.CreateDNSRecord(context.Background(), "zone", DNSRecordRequest{
+		Type:    "CNAME",
+		Name:    "a.example.com",
+		Content: "lb.example.net",
+		TTL:     1,
+		Tags:    []string{"managed:companion", "env:prod"},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type":"CNAME","name":"a.example.com","content":"lb.example.net","ttl":1,"proxied":false,"tags":["managed:companion","env:prod"]}`, string(body))
+
+	untagged, err := json.Marshal(DNSRecordRequest{Type: "CNAME"})
+	require.NoError(t, err)
+	require.NotContains(t, string(untagged), "tags")
+}
+
+func TestDNSRecordRequestDataSerialization(t *testing.T) {
+	priority := 10
+	srv := SRVMapping{Service: "_minecraft", Proto: "_tcp", Port: 25565, Priority: priority, Weight: 5}
+	raw, err := json.Marshal(DNSRecordRequest{
+		Type:     "SRV",
+		Name:     srv.recordName("mc.example.com"),
+		Content:  srv.content("host.example.net"),
+		TTL:      1,
+		Priority: &priority,
+		Data:     srv.data("host.example.net"),
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type":"SRV","name":"_minecraft._tcp.mc.example.com","content":"5 25565 host.example.net","ttl":1,"proxied":false,"priority":10,`+
+		`"data":{"priority":10,"weight":5,"port":25565,"target":"host.example.net"}}`, string(raw))
+
+	plain, err := json.Marshal(DNSRecordRequest{Type: "CNAME"})
+	require.NoError(t, err)
+	require.NotContains(t, string(plain), "data")
+}
+
+func TestCreateDNSRecordRace(t *testing.T) {
+	for _, code := range []int{cfErrHostRecordExists, cfErrRecordAlreadyExists} {
+		var lists int
+		cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintf(w, `{"success":false,"errors":[{"code":%d,"message":"Record already exists."}]}`, code)
+				return
+			}
+			lists++
+			if lists == 1 {
+				_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"other","type":"CNAME","content":"lb.example.net"}]}`))
+		})
+		comp := &Companion{cfg: Config{Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}}}, cf: cf}
+		res := &SyncResult{}
+		require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+		require.Equal(t, SyncResult{Created: 1}, *res)
+		require.Equal(t, 2, lists)
+	}
+
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":81053,"message":"A record with that host already exists."}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"a","type":"A","content":"192.0.2.1"}]}`))
+	})
+	_, err := cf.CreateDNSRecord(context.Background(), "zone", DNSRecordRequest{Type: "CNAME", Name: "a.example.com", Content: "lb.example.net"})
+	var cfErr *CloudflareError
+	require.ErrorAs(t, err, &cfErr)
+	require.True(t, cfErr.HasCode(cfErrHostRecordExists))
+	var conflict *RecordConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, []DNSRecord{{ID: "a", Type: "A", Content: "192.0.2.1"}}, conflict.Records)
+}
+
+func TestCloudflareUserAgent(t *testing.T) {
+	var agent string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	cf.SetUserAgent("docker-traefik-cloudflare-gompanion/1.2.3")
+	_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "docker-traefik-cloudflare-gompanion/1.2.3", agent)
+}
+
+func TestCloudflareReloadsRotatedTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "cf_token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("old\n"), 0o600))
+
+	var auths []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":9109,"message":"Invalid access token"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	cf.token = "old"
+	cf.SetTokenFile(tokenFile)
+
+	_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("new\n"), 0o600))
+	_, err = cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Bearer old", "Bearer old", "Bearer new"}, auths)
+}
+
+func TestCloudflareRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	cf.SetRequestTimeout(50 * time.Millisecond)
+
+	_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cf.ListDNSRecords(ctx, "zone", "a.example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCloudflareRequestTimeoutAboveDefault(t *testing.T) {
+	defaultTimeout := defaultCFRequestTimeout
+	defaultCFRequestTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { defaultCFRequestTimeout = defaultTimeout })
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+
+	_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	cf.SetRequestTimeout(time.Second)
+	_, err = cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.NoError(t, err)
+}
+
+func TestCreateDNSRecordLooksUpEmptyResult(t *testing.T) {
+	var requests []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"success":true,"result":{}}`))
+			return
+		}
+		require.Equal(t, "a.example.com", r.URL.Query().Get("name"))
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"txt","type":"TXT","content":"lb.example.net"},` +
+			`{"id":"other","type":"CNAME","content":"old.example.net"},{"id":"rec","type":"CNAME","content":"lb.example.net"},` +
+			`{"id":"spf","type":"TXT","content":"\"v=spf1 -all\""}]}`))
+	})
+
+	// A record of another type with the same content is not the one created.
+	rec, err := cf.CreateDNSRecord(context.Background(), "zone", DNSRecordRequest{Type: "CNAME", Name: "a.example.com", Content: "lb.example.net"})
+	require.NoError(t, err)
+	require.Equal(t, DNSRecord{ID: "rec", Type: "CNAME", Content: "lb.example.net"}, rec)
+	require.Equal(t, []string{http.MethodPost, http.MethodGet}, requests)
+
+	rec, err = cf.CreateDNSRecord(context.Background(), "zone", DNSRecordRequest{Type: "TXT", Name: "a.example.com", Content: "v=spf1 -all"})
+	require.NoError(t, err)
+	require.Equal(t, "spf", rec.ID)
+}
+
+func TestCreateDNSRecordReturnsResult(t *testing.T) {
+	var requests int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec","content":"lb.example.net"}}`))
+	})
+
+	rec, err := cf.CreateDNSRecord(context.Background(), "zone", DNSRecordRequest{Type: "CNAME", Name: "a.example.com", Content: "lb.example.net"})
+	require.NoError(t, err)
+	require.Equal(t, "rec", rec.ID)
+	require.Equal(t, 1, requests)
+}
+
+func TestVerifyToken(t *testing.T) {
+	status := "active"
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/user/tokens/verify", r.URL.Path)
+		if status == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":1000,"message":"Invalid API Token"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":{"id":"tok","status":"` + status + `"}}`))
+	})
+
+	require.NoError(t, cf.VerifyToken(context.Background()))
+
+	status = "expired"
+	require.EqualError(t, cf.VerifyToken(context.Background()), `token status is "expired"`)
+
+	status = ""
+	var cfErr *CloudflareError
+	require.ErrorAs(t, cf.VerifyToken(context.Background()), &cfErr)
+	require.True(t, cfErr.HasCode(1000))
+}
+
+func TestNewCloudflareTransport(t *testing.T) {
+	transport := newCloudflareTransport(32, 15*time.Second)
+	require.Equal(t, 32, transport.MaxIdleConnsPerHost)
+	require.GreaterOrEqual(t, transport.MaxIdleConns, 32)
+	require.False(t, transport.DisableKeepAlives)
+	require.NotNil(t, transport.Proxy)
+
+	require.True(t, newCloudflareTransport(1, -time.Second).DisableKeepAlives)
+}
+
+func TestDNSRecordRequestString(t *testing.T) {
+	req := DNSRecordRequest{Type: "CNAME", Name: "a.example.com", Content: "lb.example.net", TTL: 1}
+	require.Equal(t, "{Type:CNAME Name:a.example.com Content:lb.example.net TTL:auto Proxied:false Comment: Tags:[]}", fmt.Sprintf("%+v", req))
+	req.TTL = 300
+	require.Contains(t, fmt.Sprintf("%+v", req), " TTL:300 ")
+}
+
+func TestCloudflareRateLimitPacesRequests(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	cf.SetRateLimit(600)
+
+	start := time.Now()
+	for range 4 {
+		_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cf.ListDNSRecords(ctx, "zone", "a.example.com")
+	require.ErrorIs(t, err, context.Canceled)
+
+	cf.SetRateLimit(0)
+	start = time.Now()
+	for range 4 {
+		_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+		require.NoError(t, err)
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestRecordMatches(t *testing.T) {
+	rec := DNSRecord{ID: "r1", Type: "CNAME", Content: "lb.example.net", TTL: 300, Comment: "managed", Tags: []string{"b:2", "a:1"}}
+	req := DNSRecordRequest{Type: "CNAME", Name: "a.example.com", Content: "lb.example.net", TTL: 300, Comment: "managed", Tags: []string{"a:1", "b:2"}}
+	require.True(t, recordMatches(rec, req))
+
+	for name, change := range map[string]func(*DNSRecordRequest){
+		"type":    func(r *DNSRecordRequest) { r.Type = "A" },
+		"content": func(r *DNSRecordRequest) { r.Content = "other.example.net" },
+		"ttl":     func(r *DNSRecordRequest) { r.TTL = 1 },
+		"proxied": func(r *DNSRecordRequest) { r.Proxied = true },
+		"comment": func(r *DNSRecordRequest) { r.Comment = "" },
+		"tags":    func(r *DNSRecordRequest) { r.Tags = []string{"a:1"} },
+	} {
+		changed := req
+		change(&changed)
+		require.False(t, recordMatches(rec, changed), name)
+	}
+
+	require.True(t, recordMatches(DNSRecord{Type: "A", Content: "192.0.2.1", TTL: 1}, DNSRecordRequest{Type: "A", Content: "192.0.2.1", TTL: 1}))
+	// Cloudflare reports proxied records with the automatic TTL.
+	require.True(t, recordMatches(DNSRecord{Type: "A", Content: "192.0.2.1", TTL: 1, Proxied: true}, DNSRecordRequest{Type: "A", Content: "192.0.2.1", TTL: 300, Proxied: true}))
+}
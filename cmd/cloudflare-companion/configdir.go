@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// loadDomainDir loads one domain per file from dir. Files hold KEY=VALUE lines
+// using the DOMAINn setting names without the prefix (NAME, ZONE_ID, TTL, ...).
+func loadDomainDir(dir string, defaultTTL int, targetDomain string, recordType string) ([]DomainConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	doms := make([]DomainConfig, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		values, err := readKeyValueFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, required := range []string{"NAME", "ZONE_ID"} {
+			if values[required] == "" {
+				return nil, fmt.Errorf("%s: %s is not set", path, required)
+			}
+		}
+		dom, err := newDomainConfig(path, func(suffix string) string {
+			if suffix == "" {
+				return values["NAME"]
+			}
+			return values[strings.TrimPrefix(suffix, "_")]
+		}, defaultTTL, targetDomain, recordType)
+		if err != nil {
+			return nil, err
+		}
+		if dom.IncludedHosts, dom.ExcludedHosts, err = domainHostFilters(path, values); err != nil {
+			return nil, err
+		}
+		doms = append(doms, dom)
+	}
+	return doms, nil
+}
+
+func readKeyValueFile(path string) (map[string]string, error) {
+	lines, err := readConfigLines(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid line %q, expected KEY=VALUE", path, line)
+		}
+		values[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// loadHostFilterFile reads one host regex per line; a missing file yields no filters.
+func loadHostFilterFile(path string) ([]*regexp.Regexp, error) {
+	lines, err := readConfigLines(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	filters := make([]*regexp.Regexp, 0, len(lines))
+	for _, line := range lines {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in %s: %w", path, err)
+		}
+		filters = append(filters, re)
+	}
+	return filters, nil
+}
+
+func readConfigLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+}
+
+func TestLoadDomainDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "domains.d")
+	writeConfigFile(t, filepath.Join(dir, "b-example-org"), "NAME=example.org\nZONE_ID=zone2\nRC_TYPE=a\nTARGET_DOMAIN=192.0.2.10\n")
+	writeConfigFile(t, filepath.Join(dir, "a-example-com"), "# primary\nNAME=example.com\nZONE_ID=zone1\nPROXIED=true\nTTL=120\nEXCLUDED_SUB_DOMAINS=int,lan\n")
+	writeConfigFile(t, filepath.Join(dir, ".hidden"), "garbage")
+
+	doms, err := loadDomainDir(dir, 1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, []DomainConfig{
+		{Name: "example.com", RecordType: "CNAME", Proxied: true, ZoneID: "zone1", TTL: 120, TargetDomain: "lb.example.net", MXPriority: 10, ExcludedSubDomains: []string{"int", "lan"}},
+		{Name: "example.org", RecordType: "A", ZoneID: "zone2", TTL: 1, TargetDomain: "192.0.2.10", MXPriority: 10, ExcludedSubDomains: []string{}},
+	}, doms)
+
+	doms, err = loadDomainDir(filepath.Join(t.TempDir(), "missing"), 1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Empty(t, doms)
+
+	writeConfigFile(t, filepath.Join(dir, "c-broken"), "NAME=example.net\n")
+	_, err = loadDomainDir(dir, 1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, filepath.Join(dir, "c-broken")+": ZONE_ID is not set")
+}
+
+func TestLoadTraefikHostFiltersFromConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, filepath.Join(dir, "included_hosts"), `.*\.example\.com`+"\n")
+	writeConfigFile(t, filepath.Join(dir, "excluded_hosts"), "# internal\n"+`^admin\.`+"\n")
+
+	includes, excludes, err := loadTraefikHostFilters(dir, true, false)
+	require.NoError(t, err)
+	require.Len(t, includes, 1)
+	require.Len(t, excludes, 1)
+	require.True(t, includes[0].MatchString("app.example.com"))
+	require.True(t, excludes[0].MatchString("admin.example.com"))
+}
+
+func TestLoadConfigFromEnvReadsConfigDirOnlyWhenSet(t *testing.T) {
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Empty(t, cfg.ConfigDir)
+	require.Len(t, cfg.Domains, 1)
+
+	dir := t.TempDir()
+	writeConfigFile(t, filepath.Join(dir, "domains.d", "example-org"), "NAME=example.org\nZONE_ID=zone2\n")
+	t.Setenv("COMPANION_CONFIG_DIR", dir)
+	cfg, err = LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, dir, cfg.ConfigDir)
+	require.Len(t, cfg.Domains, 2)
+	require.Equal(t, "example.org", cfg.Domains[1].Name)
+}
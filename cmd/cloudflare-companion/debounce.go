@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// queueSync hands mappings to the debouncer when SYNC_DEBOUNCE_MS is set,
+// otherwise it syncs them right away.
+func (c *Companion) queueSync(ctx context.Context, source string, mappings map[string]Mapping, logger *Logger) {
+	if c.syncQueue == nil {
+		logSyncResult(logger, source, c.SyncMappings(ctx, mappings, logger))
+		return
+	}
+	if len(mappings) == 0 {
+		return
+	}
+	select {
+	case c.syncQueue <- mappings:
+	case <-ctx.Done():
+	}
+}
+
+func (c *Companion) RunSyncDebouncer(ctx context.Context, logger *Logger) {
+	window := time.Duration(c.cfg.SyncDebounceMs) * time.Millisecond
+	debounceMappings(ctx, c.syncQueue, window, func(mappings map[string]Mapping) {
+		logger.Debugf("Syncing %d debounced host(s)", len(mappings))
+		runWithRecover(logger, "sync-debouncer", func() {
+			logSyncResult(logger, "Debounced", c.SyncMappings(ctx, mappings, logger))
+		})
+	})
+}
+
+// debounceMappings merges batches received from in until no new batch arrived
+// for window, then passes the merged mappings to flush.
+func debounceMappings(ctx context.Context, in <-chan map[string]Mapping, window time.Duration, flush func(map[string]Mapping)) {
+	var pending map[string]Mapping
+	timer := time.NewTimer(window)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case mappings := <-in:
+			if pending == nil {
+				pending = map[string]Mapping{}
+			}
+			addToMappings(pending, mappings)
+			timer.Reset(window)
+		case <-timer.C:
+			if pending != nil {
+				flush(pending)
+				pending = nil
+			}
+		}
+	}
+}
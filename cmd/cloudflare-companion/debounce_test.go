@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebounceMappingsCoalescesBursts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan map[string]Mapping)
+	flushed := make(chan map[string]Mapping, 4)
+	go debounceMappings(ctx, in, 50*time.Millisecond, func(mappings map[string]Mapping) {
+		flushed <- mappings
+	})
+
+	in <- map[string]Mapping{"a.example.com": {Source: 2}}
+	in <- map[string]Mapping{"a.example.com": {Source: 1}, "b.example.com": {Source: 2}}
+
+	select {
+	case mappings := <-flushed:
+		require.Equal(t, map[string]Mapping{
+			"a.example.com": {Source: 1},
+			"b.example.com": {Source: 2},
+		}, mappings)
+	case <-time.After(time.Second):
+		t.Fatal("debounced mappings were not flushed")
+	}
+
+	in <- map[string]Mapping{"c.example.com": {Source: 1}}
+	select {
+	case mappings := <-flushed:
+		require.Equal(t, map[string]Mapping{"c.example.com": {Source: 1}}, mappings)
+	case <-time.After(time.Second):
+		t.Fatal("second batch was not flushed")
+	}
+	require.Empty(t, flushed)
+}
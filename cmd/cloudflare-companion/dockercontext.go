@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerContextEndpoint is the Docker endpoint of a context created with
+// `docker context create`, along with the TLS files stored for it.
+type dockerContextEndpoint struct {
+	Host          string
+	SkipTLSVerify bool
+	CACertFile    string
+	CertFile      string
+	KeyFile       string
+}
+
+// dockerConfigDir returns DOCKER_CONFIG or ~/.docker, where the docker CLI
+// keeps its contexts.
+func dockerConfigDir() string {
+	if dir := strings.TrimSpace(os.Getenv("DOCKER_CONFIG")); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".docker"
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// loadDockerContext reads the docker endpoint of the named context from
+// configDir. Contexts are stored under the SHA-256 of their name, with the
+// metadata in contexts/meta and the TLS files in contexts/tls.
+func loadDockerContext(configDir string, name string) (dockerContextEndpoint, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	raw, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return dockerContextEndpoint{}, fmt.Errorf("docker context %q not found in %s", name, configDir)
+	}
+	if err != nil {
+		return dockerContextEndpoint{}, err
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return dockerContextEndpoint{}, fmt.Errorf("invalid docker context %q: %w", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || strings.TrimSpace(endpoint.Host) == "" {
+		return dockerContextEndpoint{}, fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+	ctx := dockerContextEndpoint{Host: strings.TrimSpace(endpoint.Host), SkipTLSVerify: endpoint.SkipTLSVerify}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if path := filepath.Join(tlsDir, "ca.pem"); fileExists(path) {
+		ctx.CACertFile = path
+	}
+	if cert, key := filepath.Join(tlsDir, "cert.pem"), filepath.Join(tlsDir, "key.pem"); fileExists(cert) && fileExists(key) {
+		ctx.CertFile, ctx.KeyFile = cert, key
+	}
+	return ctx, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeDockerContext(t *testing.T, configDir, name, meta string, tlsFiles ...string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	metaDir := filepath.Join(configDir, "contexts", "meta", id)
+	require.NoError(t, os.MkdirAll(metaDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0o644))
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	for _, file := range tlsFiles {
+		require.NoError(t, os.MkdirAll(tlsDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(tlsDir, file), []byte("pem"), 0o600))
+	}
+	return tlsDir
+}
+
+func TestLoadDockerContext(t *testing.T) {
+	dir := t.TempDir()
+	tlsDir := writeDockerContext(t, dir, "remote",
+		`{"Name":"remote","Endpoints":{"docker":{"Host":"tcp://docker.example.com:2376","SkipTLSVerify":true}}}`,
+		"ca.pem", "cert.pem", "key.pem")
+	writeDockerContext(t, dir, "kube", `{"Name":"kube","Endpoints":{"kubernetes":{"Host":"https://k8s"}}}`)
+
+	endpoint, err := loadDockerContext(dir, "remote")
+	require.NoError(t, err)
+	require.Equal(t, dockerContextEndpoint{
+		Host:          "tcp://docker.example.com:2376",
+		SkipTLSVerify: true,
+		CACertFile:    filepath.Join(tlsDir, "ca.pem"),
+		CertFile:      filepath.Join(tlsDir, "cert.pem"),
+		KeyFile:       filepath.Join(tlsDir, "key.pem"),
+	}, endpoint)
+
+	_, err = loadDockerContext(dir, "missing")
+	require.EqualError(t, err, `docker context "missing" not found in `+dir)
+
+	_, err = loadDockerContext(dir, "kube")
+	require.EqualError(t, err, `docker context "kube" has no docker endpoint`)
+}
+
+func TestLoadConfigFromEnvAppliesDockerContext(t *testing.T) {
+	dir := t.TempDir()
+	tlsDir := writeDockerContext(t, dir, "remote",
+		`{"Name":"remote","Endpoints":{"docker":{"Host":"ssh://deploy@docker.example.com"}}}`, "ca.pem")
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_CONTEXT", "remote")
+
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "ssh://deploy@docker.example.com", cfg.DockerHost)
+	require.Equal(t, filepath.Join(tlsDir, "ca.pem"), cfg.DockerCACertFile)
+	require.Empty(t, cfg.DockerCertFile)
+
+	t.Setenv("DOCKER_HOST", "tcp://override:2375")
+	cfg, err = LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "tcp://override:2375", cfg.DockerHost)
+	require.Empty(t, cfg.DockerCACertFile)
+
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "missing")
+	_, err = LoadConfigFromEnv()
+	require.ErrorContains(t, err, `DOCKER_CONTEXT: docker context "missing" not found`)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/client"
+)
+
+// dockerSSHClientOpts returns the client options reaching the daemon of an
+// ssh:// DOCKER_HOST the way the docker CLI does: by running
+// `docker system dial-stdio` on the remote host through the ssh client, so
+// keys, agents and known hosts come from the usual SSH configuration. Other
+// hosts need no options.
+func dockerSSHClientOpts(dockerHost string) ([]client.Opt, error) {
+	helper, err := connhelper.GetConnectionHelper(dockerHost)
+	if err != nil || helper == nil {
+		return nil, err
+	}
+	return []client.Opt{client.WithHost(helper.Host), client.WithDialContext(helper.Dialer)}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSH puts an ssh script running body first on PATH and returns the file
+// its arguments are written to.
+func fakeSSH(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" + body + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestDockerSSHClientOpts(t *testing.T) {
+	opts, err := dockerSSHClientOpts("unix:///var/run/docker.sock")
+	require.NoError(t, err)
+	require.Empty(t, opts)
+
+	argsFile := fakeSSH(t, "exec cat")
+	opts, err = dockerSSHClientOpts("ssh://deploy@docker.example.com:2222")
+	require.NoError(t, err)
+	cli, err := client.NewClientWithOpts(opts...)
+	require.NoError(t, err)
+	conn, err := cli.Dialer()(context.Background())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.NoError(t, conn.Close())
+
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	require.Equal(t, "-l deploy -p 2222 -o ConnectTimeout=30 -T -- docker.example.com docker system dial-stdio\n", string(args))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const hostsFileReloadInterval = 10 * time.Second
+
+// hostsFile holds host patterns read from a file, re-read whenever the
+// file's modification time or size changes.
+type hostsFile struct {
+	path string
+
+	mu       sync.RWMutex
+	patterns []*regexp.Regexp
+	modTime  time.Time
+	size     int64
+}
+
+func newHostsFile(path string) *hostsFile {
+	return &hostsFile{path: path}
+}
+
+// Patterns returns the patterns of the last successful load. It is safe to
+// call on a nil hostsFile.
+func (h *hostsFile) Patterns() []*regexp.Regexp {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.patterns
+}
+
+// reload re-reads the file if it changed since the last load. Lines that are
+// not valid regexes are skipped with a warning.
+func (h *hostsFile) reload(logger *Logger) {
+	info, err := os.Stat(h.path)
+	if err != nil {
+		logger.Warnf("failed to read excluded hosts file: %v", err)
+		return
+	}
+	h.mu.RLock()
+	unchanged := info.ModTime().Equal(h.modTime) && info.Size() == h.size
+	h.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	lines, err := readConfigLines(h.path)
+	if err != nil {
+		logger.Warnf("failed to read excluded hosts file: %v", err)
+		return
+	}
+	patterns := make([]*regexp.Regexp, 0, len(lines))
+	for _, line := range lines {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			logger.Warnf("Ignoring invalid pattern %q in %s: %v", line, h.path, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+
+	h.mu.Lock()
+	h.patterns = patterns
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+	h.mu.Unlock()
+	logger.Infof("Loaded %d excluded host patterns from %s", len(patterns), h.path)
+}
+
+func (h *hostsFile) Watch(ctx context.Context, interval time.Duration, logger *Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reload(logger)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostsFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "excluded_hosts")
+	require.NoError(t, os.WriteFile(path, []byte("# never touch\n^internal\\.\n[invalid\n"), 0o600))
+
+	comp := &Companion{cfg: Config{IncludedHosts: matchAll}, excludedHostsFile: newHostsFile(path)}
+	buf := &bytes.Buffer{}
+	comp.excludedHostsFile.reload(newBufferLogger(buf))
+	require.Contains(t, buf.String(), `Ignoring invalid pattern "[invalid"`)
+	require.Len(t, comp.excludedHostsFile.Patterns(), 1)
+	require.False(t, comp.isHostAllowed("internal.example.com"))
+	require.True(t, comp.isHostAllowed("legacy.example.com"))
+
+	buf.Reset()
+	comp.excludedHostsFile.reload(newBufferLogger(buf))
+	require.Empty(t, buf.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("^internal\\.\n^legacy\\.\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	comp.excludedHostsFile.reload(newBufferLogger(buf))
+	require.False(t, comp.isHostAllowed("legacy.example.com"))
+
+	require.NoError(t, os.Remove(path))
+	comp.excludedHostsFile.reload(NewLogger("ERROR"))
+	require.False(t, comp.isHostAllowed("legacy.example.com"))
+
+	require.True(t, (&Companion{cfg: Config{IncludedHosts: matchAll}}).isHostAllowed("legacy.example.com"))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "companion.log")
+	f, err := openRotatingFile(path, 10)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "third\n", string(current))
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	require.Equal(t, "second\n", string(rotated))
+}
+
+func TestRotatingFileAppendsToExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "companion.log")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o644))
+
+	f, err := openRotatingFile(path, 0)
+	require.NoError(t, err)
+	logger := NewLogger("INFO")
+	logger.SetOutput(f)
+	logger.Infof("hello")
+	require.NoError(t, f.Close())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "old\n")
+	require.Contains(t, string(contents), "INFO | hello")
+}
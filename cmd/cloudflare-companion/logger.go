@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -12,8 +14,21 @@ import (
 type Logger struct {
 	level   int
 	verbose bool
-	mu      sync.Mutex
+	mu      *sync.Mutex
 	std     *log.Logger
+	prefix  string
+	fields  string
+}
+
+type cycleIDKey struct{}
+
+func withCycleID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, cycleIDKey{}, id)
+}
+
+func cycleIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(cycleIDKey{}).(string)
+	return id
 }
 
 const (
@@ -40,7 +55,38 @@ func NewLogger(levelRaw string) *Logger {
 		level = levelError
 	}
 
-	return &Logger{level: level, verbose: verbose, std: log.New(os.Stdout, "", 0)}
+	return &Logger{level: level, verbose: verbose, mu: &sync.Mutex{}, std: log.New(os.Stdout, "", 0)}
+}
+
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.std.SetOutput(w)
+}
+
+// ForContext returns a logger that tags every line with the sync cycle ID
+// carried by ctx, so lines from concurrent cycles can be told apart.
+func (l *Logger) ForContext(ctx context.Context) *Logger {
+	id := cycleIDFrom(ctx)
+	if id == "" {
+		return l
+	}
+	child := *l
+	child.prefix = l.prefix + "[cycle=" + id + "] "
+	return &child
+}
+
+// With returns a logger that appends the given key/value pairs to every
+// line, for example With("host", name, "zone", zoneID).
+func (l *Logger) With(keyvals ...string) *Logger {
+	var b strings.Builder
+	b.WriteString(l.fields)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", keyvals[i], keyvals[i+1])
+	}
+	child := *l
+	child.fields = b.String()
+	return &child
 }
 
 func (l *Logger) logf(level int, label string, format string, args ...any) {
@@ -49,7 +95,7 @@ func (l *Logger) logf(level int, label string, format string, args ...any) {
 	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.std.Printf("%s %s | %s", time.Now().Format(time.RFC3339), label, fmt.Sprintf(format, args...))
+	l.std.Printf("%s %s | %s%s%s", time.Now().Format(time.RFC3339), label, l.prefix, fmt.Sprintf(format, args...), l.fields)
 }
 
 func (l *Logger) Debugf(format string, args ...any) { l.logf(levelDebug, "DEBUG", format, args...) }
@@ -6,15 +6,23 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,83 +35,425 @@ import (
 
 type Config struct {
 	DryRun                        bool
+	RunOnce                       bool
+	CheckConfig                   bool
+	PrintMappings                 bool
+	PlanOutput                    string
 	DefaultTTL                    int
 	EnableDockerPoll              bool
 	DockerSwarmMode               bool
+	DockerSwarmIgnoreStopped      bool
+	DockerNetworkFilter           string
+	DockerIgnoreLabel             string
+	DockerReconnectMinSecs        int
+	DockerReconnectMaxSecs        int
+	DockerInspectConcurrency      int
+	DomainWriteConcurrency        int
+	DockerListLabelFilter         string
+	DockerAPIVersion              string
 	EnableTraefikPoll             bool
 	TraefikPollInsecureSkipVerify bool
 	RefreshEntries                bool
+	PreserveExistingProxied       bool
+	FixProxiedDrift               bool
+	VerifySampleRate              float64
+	MaxHostsPerSource             int
+	MaxChangesPerRun              int
+	StrictTargetValidation        bool
+	ValidateTarget                bool
+	ValidateTargetWarnOnly        bool
+	ValidateTargetTimeoutSecs     int
+	CheckDNSSEC                   bool
 	TraefikFilter                 *regexp.Regexp
 	TraefikFilterRaw              string
 	TraefikFilterKey              *regexp.Regexp
 	TraefikPollSecs               int
+	TraefikPollMinSecs            int
+	TraefikStartupTimeoutSecs     int
+	TraefikPollMaxSecs            int
 	TraefikPollURL                string
 	TraefikPollCACertFile         string
+	TraefikPollHeaders            http.Header
+	UserAgent                     string
 	TraefikVersion                string
+	TraefikExposedByDefault       bool
+	TraefikRouterOverrides        bool
+	TraefikProxiedAnnotation      string
+	TraefikUseServiceTarget       bool
+	TraefikAPIPath                string
+	TraefikIncludeStatuses        []string
+	TraefikProviderFilter         []string
 	RecordType                    string
 	TargetDomain                  string
+	TargetTemplate                string
 	Domains                       []DomainConfig
+	DomainMatchMode               string
 	IncludedHosts                 []*regexp.Regexp
+	RequireExplicitIncludes       bool
+	StrictIncludes                bool
 	ExcludedHosts                 []*regexp.Regexp
+	ExcludedHostsFile             string
 	CloudflareEmail               string
 	CloudflareToken               string
+	CloudflareTokenFile           string
+	CloudflareRequestTimeoutSecs  int
+	SkipTokenVerify               bool
+	CustomHostnames               bool
+	CustomHostnameSSLMethod       string
+	CustomHostnameSSLType         string
+	CloudflareMaxIdleConnsPerHost int
+	CloudflareKeepAliveSecs       int
+	CloudflareRateLimitPerMinute  int
+	ConfigDir                     string
+	SyncDebounceMs                int
+	InitialSyncDelaySecs          int
+	InitialSyncJitterSecs         int
+	ShutdownGraceSecs             int
+	ReconcileIntervalSecs         int
+	ZoneAuthCooldownSecs          int
+	StateFile                     string
+	CloudflareAPIBase             string
+	CloudflareAPIVersion          string
+	RecordTags                    []string
+	ProtectedContents             []string
+	LockMarker                    string
 	LogLevel                      string
+	LogFile                       string
+	LogMaxSizeMB                  int
+	LogStdout                     bool
+	DockerHost                    string
+	DockerContext                 string
 	DockerCACertFile              string
+	DockerCertFile                string
+	DockerKeyFile                 string
 	DockerInsecureSkipVerify      bool
+	AdminListen                   string
+	AdminToken                    string
+	WebhookURL                    string
 }
 
 type DomainConfig struct {
 	Name               string
+	RecordType         string
 	Proxied            bool
 	ZoneID             string
 	TTL                int
 	TargetDomain       string
 	Comment            string
+	CloudflareToken    string
+	ApexRecordType     string
+	ApexTargetDomain   string
+	MXPriority         int
 	ExcludedSubDomains []string
+	// ExcludedSubDomainRegexes are the re: entries of the excluded sub
+	// domains, matched against the full hostname.
+	ExcludedSubDomainRegexes []*regexp.Regexp
+	IncludedHosts            []*regexp.Regexp
+	ExcludedHosts            []*regexp.Regexp
 }
 
 var defaultSecretDirs = []string{"/run/secrets"}
 
+const (
+	labelExcludedSubDomains = "cloudflare.companion.excluded_subdomains"
+	labelIgnore             = "cloudflare.companion.ignore"
+	labelPriority           = "cloudflare.companion.priority"
+	labelContent            = "cloudflare.companion.content"
+	labelMXPriority         = "cloudflare.companion.mx_priority"
+	labelSRVService         = "cloudflare.companion.srv_service"
+	labelSRVProto           = "cloudflare.companion.srv_proto"
+	labelSRVPort            = "cloudflare.companion.srv_port"
+	labelSRVPriority        = "cloudflare.companion.srv_priority"
+	labelSRVWeight          = "cloudflare.companion.srv_weight"
+	labelZone               = "cloudflare.companion.zone"
+)
+
+type Mapping struct {
+	Source             int
+	Proxied            *bool
+	TTL                *int
+	ExcludedSubDomains []string
+	// ExcludedSubDomainRegexes are the re: entries of the excluded sub
+	// domains label.
+	ExcludedSubDomainRegexes []*regexp.Regexp
+	Target                   string
+	Priority                 int
+	Content                  string
+	MXPriority               *int
+	// ZoneID pins the host to the domains of that zone, bypassing the
+	// domain suffix match.
+	ZoneID string
+	SRV    *SRVMapping
+	// SRVErr reports invalid srv_* labels, failing the host when the domain
+	// writes SRV records.
+	SRVErr error
+}
+
+// SRVMapping holds the SRV record fields set by the srv_* labels. The target
+// is the domain's target, so only the service and its port are per host.
+type SRVMapping struct {
+	Service  string
+	Proto    string
+	Port     int
+	Priority int
+	Weight   int
+}
+
+// recordName returns the SRV record name "_service._proto.host".
+func (s SRVMapping) recordName(host string) string {
+	return s.Service + "." + s.Proto + "." + host
+}
+
+// content renders the SRV fields as Cloudflare lists them in the record
+// content, with the priority kept apart.
+func (s SRVMapping) content(target string) string {
+	return fmt.Sprintf("%d %d %s", s.Weight, s.Port, target)
+}
+
+func (s SRVMapping) data(target string) map[string]any {
+	return map[string]any{
+		"priority": s.Priority,
+		"weight":   s.Weight,
+		"port":     s.Port,
+		"target":   target,
+	}
+}
+
+// labelSRVMapping parses the srv_* labels, returning nil when no SRV port is
+// set. Service and proto get their leading underscore added when missing.
+func labelSRVMapping(labels map[string]string) (*SRVMapping, error) {
+	rawPort := strings.TrimSpace(labels[labelSRVPort])
+	if rawPort == "" {
+		return nil, nil
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%s must be a port between 1 and 65535, got %q", labelSRVPort, rawPort)
+	}
+	service := strings.TrimSpace(labels[labelSRVService])
+	if service == "" || service == "_" {
+		return nil, fmt.Errorf("%s is required with %s", labelSRVService, labelSRVPort)
+	}
+	srv := &SRVMapping{
+		Service: "_" + strings.TrimPrefix(service, "_"),
+		Proto:   "_" + strings.TrimPrefix(strings.ToLower(defaultString(strings.TrimSpace(labels[labelSRVProto]), "tcp")), "_"),
+		Port:    port,
+	}
+	for label, field := range map[string]*int{labelSRVPriority: &srv.Priority, labelSRVWeight: &srv.Weight} {
+		raw := strings.TrimSpace(labels[label])
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || value > 65535 {
+			return nil, fmt.Errorf("%s must be between 0 and 65535, got %q", label, raw)
+		}
+		*field = value
+	}
+	return srv, nil
+}
+
+// labelMapping reads the cloudflare.companion.* labels of owner, such as
+// "Container ID: abc", warning about excluded sub domain regexes that do not
+// compile.
+func labelMapping(labels map[string]string, owner string, logger *Logger) Mapping {
+	mapping := Mapping{Source: 1}
+	if raw, ok := labels[labelExcludedSubDomains]; ok {
+		var err error
+		mapping.ExcludedSubDomains, mapping.ExcludedSubDomainRegexes, err = parseExcludedSubDomains(raw)
+		if err != nil {
+			logger.Warnf("%s: ignoring invalid %s entries: %v", owner, labelExcludedSubDomains, err)
+		}
+	}
+	mapping.Priority = parseIntOr(strings.TrimSpace(labels[labelPriority]), 0)
+	mapping.Content = strings.TrimSpace(labels[labelContent])
+	if priority, err := strconv.Atoi(strings.TrimSpace(labels[labelMXPriority])); err == nil {
+		mapping.MXPriority = &priority
+	}
+	mapping.ZoneID = strings.TrimSpace(labels[labelZone])
+	mapping.SRV, mapping.SRVErr = labelSRVMapping(labels)
+	return mapping
+}
+
+type dockerAPI interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ServiceList(ctx context.Context, options swarm.ServiceListOptions) ([]swarm.Service, error)
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, opts swarm.ServiceInspectOptions) (swarm.Service, []byte, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+}
+
 type Companion struct {
 	cfg     Config
 	cf      *CloudflareAPI
-	docker  *client.Client
+	zoneCF  map[string]*CloudflareAPI
+	docker  dockerAPI
 	synced  map[string]int
 	syncedM sync.Mutex
+	plan    *Plan
+	sample  func() float64
+	cycleID func() string
+	dnssec  map[string]bool
+	toggles Toggles
+	webhook *Webhook
+
+	syncQueue chan map[string]Mapping
+	// traefikClient sends the Traefik API requests of every poll, reusing
+	// its connections.
+	traefikClient *http.Client
+	hostLocks     sync.Map
+	// drain, when set, replaces shutdown cancellation for in-flight syncs
+	// and is cancelled once SHUTDOWN_GRACE_SECONDS expire.
+	drain context.Context
+
+	excludedHostsFile *hostsFile
+	metrics           *requestMetrics
+	// dropped collects hosts rejected by the host filters, with the reason,
+	// while PRINT_MAPPINGS runs discovery.
+	dropped map[string]string
+
+	// stateVersion counts the changes of synced, guarded by syncedM, and
+	// stateWritten is the one last written to STATE_FILE, guarded by stateM.
+	stateVersion int
+	stateWritten int
+	stateM       sync.Mutex
+
+	// syncedMappings holds the mapping each synced host was synced with,
+	// guarded by syncedM, to count the hosts of each domain after a sync.
+	syncedMappings map[string]Mapping
+	domainMatches  map[string]int
+	domainMatchesM sync.Mutex
+
+	disabledZones  map[string]time.Time
+	disabledZonesM sync.Mutex
+
+	services  map[string]map[string]Mapping
+	servicesM sync.Mutex
+
+	// resM guards the SyncResult of a host whose domains are written
+	// concurrently.
+	resM sync.Mutex
 }
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "version") {
+		fmt.Println("cloudflare-companion " + buildInfo().String())
+		return
+	}
+
 	cfg, err := LoadConfigFromEnv()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
 	}
+	if cfg.CheckConfig || (len(os.Args) > 1 && os.Args[1] == "--check-config") {
+		fmt.Print(configSummary(cfg))
+		fmt.Println("Configuration OK")
+		return
+	}
 
 	logger := NewLogger(cfg.LogLevel)
+	if cfg.LogFile != "" {
+		logFile, err := openRotatingFile(cfg.LogFile, int64(cfg.LogMaxSizeMB)*1024*1024)
+		if err != nil {
+			logger.Errorf("failed to open log file: %v", err)
+			os.Exit(1)
+		}
+		if cfg.LogStdout {
+			logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
+		} else {
+			logger.SetOutput(logFile)
+		}
+	}
+	logger.Infof("Starting cloudflare-companion %s", buildInfo())
 
-	cf, err := NewCloudflareAPI(cfg.CloudflareEmail, cfg.CloudflareToken, logger)
+	metrics := newRequestMetrics()
+	cf, err := newCloudflareClient(cfg, cfg.CloudflareEmail, cfg.CloudflareToken, metrics, logger)
 	if err != nil {
 		logger.Errorf("failed to initialize cloudflare api: %v", err)
 		os.Exit(1)
 	}
+	cf.SetTokenFile(cfg.CloudflareTokenFile)
+	if cfg.CloudflareEmail == "" && !cfg.SkipTokenVerify {
+		if err := cf.VerifyToken(context.Background()); err != nil {
+			logger.Errorf("cloudflare token verification failed, check CF_TOKEN (set SKIP_TOKEN_VERIFY=true to bypass): %v", err)
+			os.Exit(1)
+		}
+	}
+
+	zoneCF := map[string]*CloudflareAPI{}
+	for _, dom := range cfg.Domains {
+		if dom.CloudflareToken == "" {
+			continue
+		}
+		if _, ok := zoneCF[dom.CloudflareToken]; ok {
+			continue
+		}
+		domCF, err := newCloudflareClient(cfg, "", dom.CloudflareToken, metrics, logger)
+		if err != nil {
+			logger.Errorf("failed to initialize cloudflare api for %s: %v", dom.Name, err)
+			os.Exit(1)
+		}
+		if !cfg.SkipTokenVerify {
+			if err := domCF.VerifyToken(context.Background()); err != nil {
+				logger.Errorf("cloudflare token verification failed for %s, check its CF_TOKEN (set SKIP_TOKEN_VERIFY=true to bypass): %v", dom.Name, err)
+				os.Exit(1)
+			}
+		}
+		zoneCF[dom.CloudflareToken] = domCF
+	}
 
 	comp := &Companion{
-		cfg:    cfg,
-		cf:     cf,
-		synced: map[string]int{},
+		cfg:     cfg,
+		cf:      cf,
+		zoneCF:  zoneCF,
+		metrics: metrics,
+		synced:  map[string]int{},
+		plan:    NewPlan(),
+		sample:  rand.Float64,
+	}
+	if cfg.WebhookURL != "" {
+		comp.webhook = NewWebhook(cfg.WebhookURL, cfg.UserAgent)
+	}
+	if cfg.EnableTraefikPoll {
+		traefikClient, err := newTraefikHTTPClient(cfg.TraefikPollURL, comp.traefikClientOptions())
+		if err != nil {
+			logger.Errorf("failed to configure traefik tls options: %v", err)
+			os.Exit(1)
+		}
+		comp.traefikClient = traefikClient
+	}
+	if cfg.ExcludedHostsFile != "" {
+		comp.excludedHostsFile = newHostsFile(cfg.ExcludedHostsFile)
+		comp.excludedHostsFile.reload(logger)
+	}
+	if cfg.SyncDebounceMs > 0 {
+		comp.syncQueue = make(chan map[string]Mapping)
+	}
+	if cfg.StateFile != "" {
+		synced, err := loadState(cfg.StateFile, stateFingerprint(cfg))
+		if err != nil {
+			logger.Warnf("Ignoring state, all hosts are checked against Cloudflare: %v", err)
+		} else {
+			comp.synced = synced
+			logger.Debugf("Loaded %d synced hosts from %s", len(synced), cfg.StateFile)
+		}
 	}
 
 	if cfg.EnableDockerPoll {
-		dockerOpts := []client.Opt{
-			client.FromEnv,
-			client.WithAPIVersionNegotiation(),
-		}
+		dockerOpts := dockerClientOpts(cfg)
 		if dockerHTTPClient, ok, err := newDockerHTTPClient(cfg); err != nil {
 			logger.Errorf("failed to configure docker tls options: %v", err)
 			os.Exit(1)
 		} else if ok {
 			dockerOpts = append(dockerOpts, client.WithHTTPClient(dockerHTTPClient))
 		}
+		if sshOpts, err := dockerSSHClientOpts(cfg.DockerHost); err != nil {
+			logger.Errorf("failed to configure docker ssh connection: %v", err)
+			os.Exit(1)
+		} else {
+			dockerOpts = append(dockerOpts, sshOpts...)
+		}
 
 		dockerClient, err := client.NewClientWithOpts(dockerOpts...)
 		if err != nil {
@@ -113,37 +463,165 @@ func main() {
 		comp.docker = dockerClient
 	}
 
+	if cfg.PrintMappings || (len(os.Args) > 1 && os.Args[1] == "--print-mappings") {
+		report, err := comp.mappingsReport(context.Background(), logger)
+		if err != nil {
+			logger.Errorf("failed to get initial mappings: %v", err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+
 	if cfg.DryRun {
 		logger.Warnf("Dry Run: %v", cfg.DryRun)
 	}
 	logger.Debugf("Docker Polling: %v", cfg.EnableDockerPoll)
 	logger.Debugf("Swarm Mode: %v", cfg.DockerSwarmMode)
+	logger.Debugf("Swarm Ignore Stopped Services: %v", cfg.DockerSwarmIgnoreStopped)
+	logger.Debugf("Docker Network Filter: %s", cfg.DockerNetworkFilter)
+	logger.Debugf("Docker Ignore Label: %s", cfg.DockerIgnoreLabel)
+	logger.Debugf("Docker Inspect Concurrency: %d", cfg.DockerInspectConcurrency)
+	logger.Debugf("Domain Write Concurrency: %d", cfg.DomainWriteConcurrency)
+	logger.Debugf("Docker List Label Filter: %s", cfg.DockerListLabelFilter)
+	logger.Debugf("Docker API Version: %s", defaultString(cfg.DockerAPIVersion, "negotiated"))
+	logger.Debugf("Docker Context: %s", defaultString(cfg.DockerContext, "default"))
+	logger.Debugf("Docker Host: %s", defaultString(cfg.DockerHost, "unix:///var/run/docker.sock"))
+	logger.Debugf("Docker Reconnect Min/Max Seconds: %d/%d", cfg.DockerReconnectMinSecs, cfg.DockerReconnectMaxSecs)
 	logger.Debugf("Refresh Entries: %v", cfg.RefreshEntries)
+	logger.Debugf("Preserve Existing Proxied: %v", cfg.PreserveExistingProxied)
+	logger.Debugf("Lock Marker: %s", cfg.LockMarker)
+	logger.Debugf("Fix Proxied Drift: %v", cfg.FixProxiedDrift)
+	logger.Debugf("Reconcile Interval Seconds: %d", cfg.ReconcileIntervalSecs)
+	logger.Debugf("Zone Auth Cooldown Seconds: %d", cfg.ZoneAuthCooldownSecs)
+	logger.Debugf("HTTP User Agent: %s", cfg.UserAgent)
+	logger.Debugf("Verify Sample Rate: %v", cfg.VerifySampleRate)
+	logger.Debugf("Max Hosts Per Source: %d", cfg.MaxHostsPerSource)
+	logger.Debugf("Max Changes Per Run: %d", cfg.MaxChangesPerRun)
+	logger.Debugf("Target Template: %s", cfg.TargetTemplate)
 	logger.Debugf("Traefik Version: %s", cfg.TraefikVersion)
-	logger.Debugf("Default TTL: %d", cfg.DefaultTTL)
+	logger.Debugf("Traefik Exposed By Default: %v", cfg.TraefikExposedByDefault)
+	logger.Debugf("Default TTL: %s", formatTTL(cfg.DefaultTTL))
+	logger.Debugf("Domain Match Mode: %s", cfg.DomainMatchMode)
 
 	if cfg.EnableTraefikPoll {
 		logger.Debugf("Traefik Poll Url: %s", cfg.TraefikPollURL)
+		logger.Debugf("Traefik API Path: %s", cfg.TraefikAPIPath)
+		logger.Debugf("Traefik Include Statuses: %v", cfg.TraefikIncludeStatuses)
+		logger.Debugf("Traefik Provider Filter: %v", cfg.TraefikProviderFilter)
 		logger.Debugf("Traefik Poll Seconds: %d", cfg.TraefikPollSecs)
+		logger.Debugf("Traefik Poll Min/Max Seconds: %d/%d", cfg.TraefikPollMinSecs, cfg.TraefikPollMaxSecs)
+		logger.Debugf("Traefik Startup Timeout Seconds: %d", cfg.TraefikStartupTimeoutSecs)
 		logger.Debugf("Traefik Poll CA Cert File: %s", cfg.TraefikPollCACertFile)
 		logger.Debugf("Traefik Poll Insecure Skip Verify: %v", cfg.TraefikPollInsecureSkipVerify)
+		logger.Debugf("Traefik Poll Headers: %v", slices.Sorted(maps.Keys(cfg.TraefikPollHeaders)))
+		logger.Debugf("Traefik Router Overrides: %v", cfg.TraefikRouterOverrides)
+		logger.Debugf("Traefik Proxied Annotation: %s", cfg.TraefikProxiedAnnotation)
+		logger.Debugf("Traefik Use Service Target: %v", cfg.TraefikUseServiceTarget)
+	}
+
+	for _, overlap := range overlappingDomains(cfg.Domains) {
+		if cfg.DomainMatchMode == domainMatchLongestSuffix {
+			logger.Warnf("Domain %s overlaps %s, hosts under %s are only written to %s", overlap[0], overlap[1], overlap[1], overlap[1])
+		} else {
+			logger.Warnf("Domain %s overlaps %s, hosts under %s are written to both zones (set DOMAIN_MATCH_MODE=%s to only use the most specific)", overlap[0], overlap[1], overlap[1], domainMatchLongestSuffix)
+		}
+	}
+
+	if cfg.CheckDNSSEC {
+		comp.CheckDNSSEC(logger)
+	}
+
+	if cfg.ValidateTarget {
+		timeout := time.Duration(cfg.ValidateTargetTimeoutSecs) * time.Second
+		if err := validateTargets(context.Background(), cfg, timeout, net.DefaultResolver.LookupHost); err != nil {
+			if !cfg.ValidateTargetWarnOnly {
+				logger.Errorf("target validation failed: %v", err)
+				os.Exit(1)
+			}
+			logger.Warnf("target validation failed: %v", err)
+		}
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	if cfg.ShutdownGraceSecs > 0 {
+		comp.drain = drainCtx
+	}
+
+	wg := &sync.WaitGroup{}
+	if cfg.AdminListen != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.RunAdminServer(ctx, logger)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		comp.WatchToggleSignal(ctx, logger)
+	}()
+
+	if comp.excludedHostsFile != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.excludedHostsFile.Watch(ctx, hostsFileReloadInterval, logger)
+		}()
+	}
+
+	if delay := initialSyncDelay(cfg.InitialSyncDelaySecs, cfg.InitialSyncJitterSecs, rand.Float64); delay > 0 {
+		logger.Infof("Delaying initial sync by %s", delay)
+		if !sleepContext(ctx, delay) {
+			wg.Wait()
+			return
+		}
+	}
+
+	if cfg.EnableTraefikPoll && cfg.TraefikStartupTimeoutSecs > 0 {
+		comp.waitForTraefik(ctx, traefikStartupBackoff, logger)
+		if ctx.Err() != nil {
+			wg.Wait()
+			return
+		}
+	}
 
-	initialMappings := map[string]int{}
+	initialMappings := map[string]Mapping{}
 	runWithRecover(logger, "initial-mapping", func() {
 		mappings, err := comp.GetInitialMappings(ctx, logger)
 		if err != nil {
 			logger.Errorf("failed to get initial mappings: %v", err)
+			comp.plan.Fail("initial-mapping", err)
 			return
 		}
 		initialMappings = mappings
 	})
-	comp.SyncMappings(initialMappings, logger)
+	result := comp.SyncMappings(ctx, initialMappings, logger)
+	logger.Infof("Initial sync: %s", result)
+	comp.checkSyncedDomainMatches(logger)
+
+	if cfg.RunOnce {
+		cancel()
+		wg.Wait()
+		exitCode := comp.FinishRun(logger)
+		if result.Failed > 0 {
+			exitCode = 1
+		}
+		os.Exit(exitCode)
+	}
+
+	if comp.syncQueue != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.RunSyncDebouncer(ctx, logger)
+		}()
+	}
 
-	wg := &sync.WaitGroup{}
 	if cfg.EnableTraefikPoll {
 		wg.Add(1)
 		go func() {
@@ -160,28 +638,196 @@ func main() {
 		}()
 	}
 
+	if cfg.ReconcileIntervalSecs > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.RunReconciler(ctx, logger)
+		}()
+	}
+
 	<-ctx.Done()
-	wg.Wait()
+	grace := time.Duration(cfg.ShutdownGraceSecs) * time.Second
+	if !waitWithGrace(wg, grace) {
+		logger.Warnf("Shutdown grace period of %s expired, cancelling in-flight syncs", grace)
+		cancelDrain()
+		wg.Wait()
+	}
+	comp.FinishRun(logger)
+}
+
+// waitWithGrace waits for wg, giving up after grace. It reports whether wg
+// finished in time. Without a grace period the syncs are not drained, so
+// they stop with the cancelled context and wg is waited for as is.
+func waitWithGrace(wg *sync.WaitGroup, grace time.Duration) bool {
+	if grace <= 0 {
+		wg.Wait()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// initialSyncDelay returns the configured delay plus a random jitter of up to
+// jitterSecs, so instances restarted together do not all sync at once.
+func initialSyncDelay(delaySecs int, jitterSecs int, random func() float64) time.Duration {
+	delay := time.Duration(max(delaySecs, 0)) * time.Second
+	if jitterSecs > 0 {
+		delay += time.Duration(random() * float64(time.Duration(jitterSecs)*time.Second))
+	}
+	return delay
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (c *Companion) FinishRun(logger *Logger) int {
+	if c.cfg.DryRun {
+		logger.Infof("DRY-RUN summary: %s", c.plan.Summary())
+	}
+	exitCode := 0
+	if c.cfg.PlanOutput != "" {
+		if err := c.plan.WriteFile(c.cfg.PlanOutput, c.cfg.DryRun); err != nil {
+			logger.Errorf("failed to write plan output: %v", err)
+			exitCode = 1
+		}
+	}
+	if c.plan.HasErrors() {
+		exitCode = 1
+	}
+	return exitCode
 }
 
 func LoadConfigFromEnv() (Config, error) {
 	cfg := Config{}
 	cfg.DryRun = parseBoolLikePython(os.Getenv("DRY_RUN"), false)
-	cfg.DefaultTTL = parseIntOr(os.Getenv("DEFAULT_TTL"), 1)
+	cfg.RunOnce = parseBoolLikePython(os.Getenv("RUN_ONCE"), false)
+	cfg.CheckConfig = parseBoolLikePython(os.Getenv("CHECK_CONFIG"), false)
+	cfg.PrintMappings = parseBoolLikePython(os.Getenv("PRINT_MAPPINGS"), false)
+	cfg.PlanOutput = strings.TrimSpace(os.Getenv("PLAN_OUTPUT"))
+	cfg.DefaultTTL = parseTTL(os.Getenv("DEFAULT_TTL"), ttlAuto)
+	if err := validateTTL(cfg.DefaultTTL); err != nil {
+		return cfg, fmt.Errorf("DEFAULT_TTL: %w", err)
+	}
 	cfg.EnableDockerPoll = parseBoolLikePython(os.Getenv("ENABLE_DOCKER_POLL"), true)
 	cfg.DockerSwarmMode = parseBoolLikePython(os.Getenv("DOCKER_SWARM_MODE"), false)
+	cfg.DockerSwarmIgnoreStopped = parseBoolLikePython(os.Getenv("DOCKER_SWARM_IGNORE_STOPPED_SERVICES"), false)
+	cfg.DockerNetworkFilter = strings.TrimSpace(os.Getenv("DOCKER_NETWORK_FILTER"))
+	cfg.DockerIgnoreLabel = defaultString(strings.TrimSpace(os.Getenv("DOCKER_IGNORE_LABEL")), labelIgnore)
+	cfg.DockerInspectConcurrency = parseIntOr(os.Getenv("DOCKER_INSPECT_CONCURRENCY"), 8)
+	cfg.DomainWriteConcurrency = parseIntOr(os.Getenv("DOMAIN_WRITE_CONCURRENCY"), 4)
+	cfg.DockerListLabelFilter = strings.TrimSpace(os.Getenv("DOCKER_LIST_LABEL_FILTER"))
+	cfg.DockerAPIVersion = strings.TrimPrefix(strings.TrimSpace(os.Getenv("DOCKER_API_VERSION")), "v")
+	if cfg.DockerAPIVersion != "" && !dockerAPIVersionPattern.MatchString(cfg.DockerAPIVersion) {
+		return cfg, fmt.Errorf("DOCKER_API_VERSION must look like 1.41, got %q", cfg.DockerAPIVersion)
+	}
+	cfg.DockerReconnectMinSecs = parseIntOr(os.Getenv("DOCKER_RECONNECT_MIN_SECS"), 2)
+	cfg.DockerReconnectMaxSecs = parseIntOr(os.Getenv("DOCKER_RECONNECT_MAX_SECS"), 60)
 	cfg.EnableTraefikPoll = parseBoolLikePython(os.Getenv("ENABLE_TRAEFIK_POLL"), false)
 	cfg.TraefikPollInsecureSkipVerify = parseBoolLikePython(os.Getenv("TRAEFIK_POLL_INSECURE_SKIP_VERIFY"), false)
 	cfg.RefreshEntries = parseBoolLikePython(os.Getenv("REFRESH_ENTRIES"), false)
+	cfg.PreserveExistingProxied = parseBoolLikePython(os.Getenv("PRESERVE_EXISTING_PROXIED"), false)
+	cfg.FixProxiedDrift = parseBoolLikePython(os.Getenv("FIX_PROXIED_DRIFT"), false)
+	cfg.SyncDebounceMs = parseIntOr(os.Getenv("SYNC_DEBOUNCE_MS"), 0)
+	cfg.InitialSyncDelaySecs = parseIntOr(os.Getenv("INITIAL_SYNC_DELAY_SECONDS"), 0)
+	cfg.InitialSyncJitterSecs = parseIntOr(os.Getenv("INITIAL_SYNC_JITTER_SECONDS"), 0)
+	cfg.ShutdownGraceSecs = parseIntOr(os.Getenv("SHUTDOWN_GRACE_SECONDS"), 10)
+	if cfg.ShutdownGraceSecs < 0 {
+		return cfg, errors.New("SHUTDOWN_GRACE_SECONDS must not be negative")
+	}
+	cfg.ReconcileIntervalSecs = parseIntOr(os.Getenv("RECONCILE_INTERVAL_SECONDS"), 0)
+	cfg.ZoneAuthCooldownSecs = parseIntOr(os.Getenv("ZONE_AUTH_COOLDOWN_SECONDS"), 3600)
+	cfg.StateFile = strings.TrimSpace(os.Getenv("STATE_FILE"))
+	cfg.VerifySampleRate = parseFloatOr(os.Getenv("VERIFY_SAMPLE_RATE"), 0)
+	cfg.MaxHostsPerSource = parseIntOr(os.Getenv("MAX_HOSTS_PER_SOURCE"), 0)
+	cfg.MaxChangesPerRun = parseIntOr(os.Getenv("MAX_CHANGES_PER_RUN"), 0)
+	cfg.StrictTargetValidation = parseBoolLikePython(os.Getenv("STRICT_TARGET_VALIDATION"), false)
+	cfg.ValidateTarget = parseBoolLikePython(os.Getenv("VALIDATE_TARGET"), false)
+	cfg.ValidateTargetWarnOnly = parseBoolLikePython(os.Getenv("VALIDATE_TARGET_WARN_ONLY"), false)
+	cfg.ValidateTargetTimeoutSecs = parseIntOr(os.Getenv("VALIDATE_TARGET_TIMEOUT_SECONDS"), 5)
+	cfg.CheckDNSSEC = parseBoolLikePython(os.Getenv("CHECK_DNSSEC"), false)
 	cfg.LogLevel = defaultString(os.Getenv("LOG_LEVEL"), "INFO")
+	cfg.LogFile = strings.TrimSpace(os.Getenv("LOG_FILE"))
+	cfg.LogMaxSizeMB = parseIntOr(os.Getenv("LOG_MAX_SIZE_MB"), 0)
+	cfg.LogStdout = parseBoolLikePython(os.Getenv("LOG_STDOUT"), true)
 	cfg.TraefikPollSecs = parseIntOr(os.Getenv("TRAEFIK_POLL_SECONDS"), 60)
+	cfg.TraefikPollMinSecs = parseIntOr(os.Getenv("TRAEFIK_POLL_MIN_SECS"), cfg.TraefikPollSecs)
+	cfg.TraefikStartupTimeoutSecs = parseIntOr(os.Getenv("TRAEFIK_STARTUP_TIMEOUT_SECONDS"), 0)
+	cfg.TraefikPollMaxSecs = parseIntOr(os.Getenv("TRAEFIK_POLL_MAX_SECS"), cfg.TraefikPollSecs)
 	cfg.TraefikPollURL = os.Getenv("TRAEFIK_POLL_URL")
+	cfg.TraefikAPIPath = defaultString(os.Getenv("TRAEFIK_API_PATH"), "/api")
+	cfg.TraefikProviderFilter = parseProviderFilter(os.Getenv("TRAEFIK_PROVIDER_FILTER"))
+	cfg.TraefikIncludeStatuses = splitCleanCSV(strings.ToLower(defaultString(os.Getenv("TRAEFIK_INCLUDE_STATUSES"), "enabled")))
 	cfg.TraefikPollCACertFile = os.Getenv("TRAEFIK_POLL_CA_CERT_FILE")
+	cfg.UserAgent = defaultString(strings.TrimSpace(os.Getenv("HTTP_USER_AGENT")), defaultUserAgent())
+	cfg.TraefikPollHeaders = traefikPollHeaders()
+	if cfg.TraefikPollHeaders.Get("User-Agent") == "" {
+		cfg.TraefikPollHeaders.Set("User-Agent", cfg.UserAgent)
+	}
 	cfg.TraefikVersion = defaultString(os.Getenv("TRAEFIK_VERSION"), "2")
+	cfg.TraefikExposedByDefault = parseBoolLikePython(os.Getenv("TRAEFIK_EXPOSED_BY_DEFAULT"), true)
+	cfg.TraefikRouterOverrides = parseBoolLikePython(os.Getenv("TRAEFIK_ROUTER_OVERRIDES"), false)
+	cfg.TraefikProxiedAnnotation = strings.TrimSpace(os.Getenv("TRAEFIK_PROXIED_ANNOTATION"))
+	cfg.TraefikUseServiceTarget = parseBoolLikePython(os.Getenv("TRAEFIK_USE_SERVICE_TARGET"), false)
 	cfg.DockerCACertFile = os.Getenv("DOCKER_CA_CERT_FILE")
+	cfg.DockerCertFile = strings.TrimSpace(os.Getenv("DOCKER_CERT_FILE"))
+	cfg.DockerKeyFile = strings.TrimSpace(os.Getenv("DOCKER_KEY_FILE"))
+	if (cfg.DockerCertFile == "") != (cfg.DockerKeyFile == "") {
+		return cfg, errors.New("DOCKER_CERT_FILE and DOCKER_KEY_FILE must be set together")
+	}
 	cfg.DockerInsecureSkipVerify = parseBoolLikePython(os.Getenv("DOCKER_INSECURE_SKIP_VERIFY"), false)
-	cfg.RecordType = defaultString(os.Getenv("RC_TYPE"), "CNAME")
+	cfg.DockerHost = strings.TrimSpace(os.Getenv("DOCKER_HOST"))
+	cfg.DockerContext = strings.TrimSpace(os.Getenv("DOCKER_CONTEXT"))
+	// As with the docker CLI, DOCKER_HOST takes precedence over a context.
+	if cfg.DockerHost == "" && cfg.DockerContext != "" && cfg.DockerContext != "default" {
+		endpoint, err := loadDockerContext(dockerConfigDir(), cfg.DockerContext)
+		if err != nil {
+			return cfg, fmt.Errorf("DOCKER_CONTEXT: %w", err)
+		}
+		cfg.DockerHost = endpoint.Host
+		cfg.DockerCACertFile = defaultString(cfg.DockerCACertFile, endpoint.CACertFile)
+		if cfg.DockerCertFile == "" {
+			cfg.DockerCertFile, cfg.DockerKeyFile = endpoint.CertFile, endpoint.KeyFile
+		}
+		cfg.DockerInsecureSkipVerify = cfg.DockerInsecureSkipVerify || endpoint.SkipTLSVerify
+	}
+	cfg.AdminListen = strings.TrimSpace(os.Getenv("ADMIN_LISTEN"))
+	cfg.AdminToken = getSecretByEnv("ADMIN_TOKEN")
+	cfg.WebhookURL = strings.TrimSpace(os.Getenv("WEBHOOK_URL"))
+	if cfg.WebhookURL != "" && !validURI(cfg.WebhookURL) {
+		return cfg, errors.New("invalid WEBHOOK_URL")
+	}
+	cfg.RecordType = strings.ToUpper(strings.TrimSpace(defaultString(os.Getenv("RC_TYPE"), "CNAME")))
+	if !slices.Contains(supportedRecordTypes, cfg.RecordType) {
+		return cfg, fmt.Errorf("RC_TYPE must be one of %s, got %q", strings.Join(supportedRecordTypes, ", "), cfg.RecordType)
+	}
+	cfg.RecordTags = splitCleanCSV(os.Getenv("CF_RECORD_TAGS"))
+	cfg.ProtectedContents = splitCleanCSV(os.Getenv("PROTECTED_CONTENTS"))
+	cfg.LockMarker = strings.TrimSpace(os.Getenv("LOCK_MARKER"))
 	cfg.TargetDomain = os.Getenv("TARGET_DOMAIN")
+	cfg.TargetTemplate = strings.TrimSpace(os.Getenv("TARGET_TEMPLATE"))
+	if err := validateTargetTemplate(cfg.TargetTemplate); err != nil {
+		return cfg, fmt.Errorf("TARGET_TEMPLATE: %w", err)
+	}
 
 	filterLabel := defaultString(os.Getenv("TRAEFIK_FILTER_LABEL"), "traefik.constraint")
 	labelRegex, err := regexp.Compile(filterLabel)
@@ -200,38 +846,82 @@ func LoadConfigFromEnv() (Config, error) {
 	}
 
 	cfg.CloudflareEmail = getSecretByEnv("CF_EMAIL")
-	cfg.CloudflareToken = getSecretByEnv("CF_TOKEN")
+	cfg.CloudflareToken, cfg.CloudflareTokenFile = resolveSecretByEnv("CF_TOKEN")
+	cfg.CloudflareAPIBase = defaultString(os.Getenv("CF_API_BASE"), cloudflareAPIBase)
+	cfg.CloudflareAPIVersion = defaultString(os.Getenv("CF_API_VERSION"), cloudflareAPIVersion)
+	cfg.CloudflareMaxIdleConnsPerHost = parseIntOr(os.Getenv("CF_MAX_IDLE_CONNS_PER_HOST"), defaultCFMaxIdleConnsPerHost)
+	cfg.CloudflareKeepAliveSecs = parseIntOr(os.Getenv("CF_KEEPALIVE_SECONDS"), int(defaultCFKeepAlive/time.Second))
+	cfg.CloudflareRateLimitPerMinute = parseIntOr(os.Getenv("CF_RATE_LIMIT_PER_MINUTE"), 0)
+	cfg.CustomHostnames = parseBoolLikePython(os.Getenv("CF_CUSTOM_HOSTNAMES"), false)
+	cfg.CustomHostnameSSLMethod = defaultString(os.Getenv("CF_CUSTOM_HOSTNAME_SSL_METHOD"), "http")
+	cfg.CustomHostnameSSLType = defaultString(os.Getenv("CF_CUSTOM_HOSTNAME_SSL_TYPE"), "dv")
+	cfg.SkipTokenVerify = parseBoolLikePython(os.Getenv("SKIP_TOKEN_VERIFY"), false)
+	cfg.CloudflareRequestTimeoutSecs = parseIntOr(os.Getenv("CF_REQUEST_TIMEOUT_SECONDS"), 20)
+	if cfg.CloudflareRequestTimeoutSecs <= 0 {
+		return cfg, errors.New("CF_REQUEST_TIMEOUT_SECONDS must be positive")
+	}
 	if cfg.CloudflareToken == "" {
 		return cfg, errors.New("CF_TOKEN not defined")
 	}
-	if cfg.TargetDomain == "" {
-		return cfg, errors.New("TARGET_DOMAIN not defined")
-	}
 
-	domains, err := loadDomainConfigs(cfg.DefaultTTL, cfg.TargetDomain)
+	domains, err := loadDomainConfigs(cfg.DefaultTTL, cfg.TargetDomain, cfg.RecordType)
 	if err != nil {
 		return cfg, err
 	}
+	cfg.ConfigDir = os.Getenv("COMPANION_CONFIG_DIR")
+	if cfg.ConfigDir != "" {
+		dirDomains, err := loadDomainDir(filepath.Join(cfg.ConfigDir, "domains.d"), cfg.DefaultTTL, cfg.TargetDomain, cfg.RecordType)
+		if err != nil {
+			return cfg, err
+		}
+		domains = append(domains, dirDomains...)
+	}
 	if len(domains) == 0 {
 		return cfg, errors.New("DOMAIN1 not defined")
 	}
 	cfg.Domains = domains
 
+	cfg.DomainMatchMode = strings.ToLower(defaultString(os.Getenv("DOMAIN_MATCH_MODE"), domainMatchAll))
+	if cfg.DomainMatchMode != domainMatchAll && cfg.DomainMatchMode != domainMatchLongestSuffix {
+		return cfg, fmt.Errorf("DOMAIN_MATCH_MODE must be %s or %s", domainMatchAll, domainMatchLongestSuffix)
+	}
+
+	if cfg.TraefikPollMinSecs <= 0 || cfg.TraefikPollMaxSecs <= 0 {
+		return cfg, errors.New("TRAEFIK_POLL_MIN_SECS and TRAEFIK_POLL_MAX_SECS must be positive")
+	}
+	if cfg.TraefikPollMinSecs > cfg.TraefikPollMaxSecs {
+		return cfg, errors.New("TRAEFIK_POLL_MIN_SECS cannot be greater than TRAEFIK_POLL_MAX_SECS")
+	}
+
+	if cfg.DockerReconnectMinSecs <= 0 || cfg.DockerReconnectMaxSecs <= 0 {
+		return cfg, errors.New("DOCKER_RECONNECT_MIN_SECS and DOCKER_RECONNECT_MAX_SECS must be positive")
+	}
+	if cfg.DockerReconnectMinSecs > cfg.DockerReconnectMaxSecs {
+		return cfg, errors.New("DOCKER_RECONNECT_MIN_SECS cannot be greater than DOCKER_RECONNECT_MAX_SECS")
+	}
+
+	if cfg.VerifySampleRate < 0 || cfg.VerifySampleRate > 1 {
+		return cfg, errors.New("VERIFY_SAMPLE_RATE must be between 0 and 1")
+	}
+
 	if !cfg.EnableDockerPoll && cfg.DockerSwarmMode {
 		return cfg, errors.New("cannot enable DOCKER_SWARM_MODE without ENABLE_DOCKER_POLL=true")
 	}
 
-	included, excluded, err := loadTraefikHostFilters()
+	cfg.RequireExplicitIncludes = parseBoolLikePython(os.Getenv("TRAEFIK_REQUIRE_EXPLICIT_INCLUDES"), false)
+	cfg.StrictIncludes = parseBoolLikePython(os.Getenv("TRAEFIK_STRICT_INCLUDES"), false)
+	included, excluded, err := loadTraefikHostFilters(cfg.ConfigDir, cfg.RequireExplicitIncludes, cfg.StrictIncludes)
 	if err != nil {
 		return cfg, err
 	}
 	cfg.IncludedHosts = included
 	cfg.ExcludedHosts = excluded
+	cfg.ExcludedHostsFile = strings.TrimSpace(os.Getenv("EXCLUDED_HOSTS_FILE"))
 
 	if cfg.EnableTraefikPoll {
 		if cfg.TraefikVersion != "2" {
 			cfg.EnableTraefikPoll = false
-		} else if !validURI(cfg.TraefikPollURL) {
+		} else if !validURI(cfg.TraefikPollURL) && traefikSocket(cfg.TraefikPollURL) == "" {
 			cfg.EnableTraefikPoll = false
 		}
 	}
@@ -239,7 +929,7 @@ func LoadConfigFromEnv() (Config, error) {
 	return cfg, nil
 }
 
-func loadDomainConfigs(defaultTTL int, targetDomain string) ([]DomainConfig, error) {
+func loadDomainConfigs(defaultTTL int, targetDomain string, recordType string) ([]DomainConfig, error) {
 	rxDoms := regexp.MustCompile(`(?i)^DOMAIN[0-9]+$`)
 	keys := make([]string, 0)
 	for _, key := range os.Environ() {
@@ -252,124 +942,633 @@ func loadDomainConfigs(defaultTTL int, targetDomain string) ([]DomainConfig, err
 
 	doms := make([]DomainConfig, 0, len(keys))
 	for _, key := range keys {
-		name := os.Getenv(key)
-		zone := getSecretByEnv(key + "_ZONE_ID")
-		if zone == "" {
-			return nil, fmt.Errorf("%s is not set", key+"_ZONE_ID")
-		}
-		ttl := parseIntOr(os.Getenv(key+"_TTL"), defaultTTL)
-		target := defaultString(os.Getenv(key+"_TARGET_DOMAIN"), targetDomain)
-		excluded := splitCleanCSV(os.Getenv(key + "_EXCLUDED_SUB_DOMAINS"))
-		doms = append(doms, DomainConfig{
-			Name:               name,
-			Proxied:            parseBoolLikePython(os.Getenv(key+"_PROXIED"), false),
-			ZoneID:             zone,
-			TTL:                ttl,
-			TargetDomain:       target,
-			Comment:            os.Getenv(key + "_COMMENT"),
-			ExcludedSubDomains: excluded,
-		})
+		dom, err := newDomainConfig(key, func(suffix string) string {
+			if suffix == "_ZONE_ID" || suffix == "_CF_TOKEN" {
+				return getSecretByEnv(key + suffix)
+			}
+			return os.Getenv(key + suffix)
+		}, defaultTTL, targetDomain, recordType)
+		if err != nil {
+			return nil, err
+		}
+		settings := map[string]string{}
+		for _, kv := range os.Environ() {
+			name, value, _ := strings.Cut(kv, "=")
+			if suffix, ok := strings.CutPrefix(name, key+"_"); ok {
+				settings[suffix] = value
+			}
+		}
+		if dom.IncludedHosts, dom.ExcludedHosts, err = domainHostFilters(key, settings); err != nil {
+			return nil, err
+		}
+		doms = append(doms, dom)
 	}
 
 	return doms, nil
 }
 
-func loadTraefikHostFilters() ([]*regexp.Regexp, []*regexp.Regexp, error) {
-	rInc := regexp.MustCompile(`(?i)^TRAEFIK_INCLUDED_HOST[0-9]+$`)
-	rExc := regexp.MustCompile(`(?i)^TRAEFIK_EXCLUDED_HOST[0-9]+$`)
+var (
+	domainIncludedHostKey = regexp.MustCompile(`^INCLUDED_HOST[0-9]+$`)
+	domainExcludedHostKey = regexp.MustCompile(`^EXCLUDED_HOST[0-9]+$`)
+)
 
-	includes := make([]*regexp.Regexp, 0)
-	excludes := make([]*regexp.Regexp, 0)
-	for _, kv := range os.Environ() {
-		parts := strings.SplitN(kv, "=", 2)
-		name := parts[0]
-		val := ""
-		if len(parts) == 2 {
-			val = parts[1]
+// domainHostFilters compiles the INCLUDED_HOSTn/EXCLUDED_HOSTn settings of a
+// domain, given its settings keyed by name without the DOMAINn_ prefix.
+func domainHostFilters(key string, settings map[string]string) ([]*regexp.Regexp, []*regexp.Regexp, error) {
+	names := slices.Sorted(maps.Keys(settings))
+	var includes, excludes []*regexp.Regexp
+	for _, name := range names {
+		included := domainIncludedHostKey.MatchString(name)
+		if !included && !domainExcludedHostKey.MatchString(name) {
+			continue
 		}
-		if rInc.MatchString(name) {
-			re, err := regexp.Compile(val)
-			if err != nil {
-				return nil, nil, fmt.Errorf("invalid %s regex: %w", name, err)
-			}
-			includes = append(includes, re)
+		re, err := regexp.Compile(settings[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: invalid %s regex: %w", key, name, err)
 		}
-		if rExc.MatchString(name) {
-			re, err := regexp.Compile(val)
-			if err != nil {
-				return nil, nil, fmt.Errorf("invalid %s regex: %w", name, err)
-			}
+		if included {
+			includes = append(includes, re)
+		} else {
 			excludes = append(excludes, re)
 		}
 	}
-
-	if len(includes) == 0 {
-		includes = append(includes, regexp.MustCompile(`.*`))
-	}
-
 	return includes, excludes, nil
 }
 
-func (c *Companion) GetInitialMappings(ctx context.Context, logger *Logger) (map[string]int, error) {
-	mappings := map[string]int{}
+func isDomainHostAllowed(host string, dom DomainConfig) bool {
+	if len(dom.IncludedHosts) > 0 && !isMatching(host, dom.IncludedHosts) {
+		return false
+	}
+	return !isMatching(host, dom.ExcludedHosts)
+}
 
-	if c.cfg.EnableDockerPoll {
-		containers, err := c.docker.ContainerList(ctx, container.ListOptions{})
-		if err != nil {
-			return nil, err
+// newDomainConfig builds a domain from DOMAINn style settings, where get
+// returns the value for a suffix such as "_ZONE_ID" ("" is the name itself).
+func newDomainConfig(key string, get func(suffix string) string, defaultTTL int, targetDomain string, recordType string) (DomainConfig, error) {
+	if profile := get("_PROFILE"); profile != "" {
+		if !profileDefined(profile) {
+			return DomainConfig{}, fmt.Errorf("%s references undefined profile %q", key, profile)
 		}
-		for _, ctr := range containers {
-			json, err := c.docker.ContainerInspect(ctx, ctr.ID)
-			if err != nil {
-				continue
-			}
-			if c.cfg.TraefikVersion == "1" {
-				addToMappings(mappings, c.checkContainerT1(json.ID, json.Config.Labels, logger))
-			} else {
-				addToMappings(mappings, c.checkContainerT2(json.ID, json.Config.Labels, logger))
+		own := get
+		get = func(suffix string) string {
+			if value := own(suffix); value != "" || suffix == "" || suffix == "_ZONE_ID" {
+				return value
 			}
+			return os.Getenv("PROFILE_" + profile + suffix)
 		}
 	}
-
-	if c.cfg.DockerSwarmMode {
-		services, err := c.docker.ServiceList(ctx, swarm.ServiceListOptions{})
-		if err != nil {
-			return nil, err
+	zone := get("_ZONE_ID")
+	if zone == "" {
+		return DomainConfig{}, fmt.Errorf("%s is not set", key+"_ZONE_ID")
+	}
+	ttl := parseTTL(get("_TTL"), defaultTTL)
+	if err := validateTTL(ttl); err != nil {
+		return DomainConfig{}, fmt.Errorf("%s_TTL: %w", key, err)
+	}
+	target := defaultString(get("_TARGET_DOMAIN"), targetDomain)
+	excluded, excludedRegexes, err := parseExcludedSubDomains(get("_EXCLUDED_SUB_DOMAINS"))
+	if err != nil {
+		return DomainConfig{}, fmt.Errorf("%s_EXCLUDED_SUB_DOMAINS: %w", key, err)
+	}
+	rcType := strings.ToUpper(strings.TrimSpace(defaultString(get("_RC_TYPE"), recordType)))
+	if !slices.Contains(supportedRecordTypes, rcType) {
+		return DomainConfig{}, fmt.Errorf("%s_RC_TYPE must be one of %s, got %q", key, strings.Join(supportedRecordTypes, ", "), rcType)
+	}
+	// An address domain can take its IP from _TARGET_IP, leaving
+	// TARGET_DOMAIN to the CNAME domains of a mixed setup.
+	if targetIP := strings.TrimSpace(get("_TARGET_IP")); targetIP != "" {
+		if rcType != "A" && rcType != "AAAA" {
+			return DomainConfig{}, fmt.Errorf("%s_TARGET_IP needs A or AAAA records, got %s", key, rcType)
 		}
-		for _, svc := range services {
-			if c.cfg.TraefikVersion == "1" {
-				if svc.Spec.TaskTemplate.ContainerSpec != nil {
-					addToMappings(mappings, c.checkServiceT1(svc.ID, svc.Spec.TaskTemplate.ContainerSpec.Labels, logger))
-				}
-			} else {
-				addToMappings(mappings, c.checkServiceT2(svc.ID, svc.Spec.Labels, logger))
-			}
+		if err := validateTargetList(rcType, targetIP); err != nil {
+			return DomainConfig{}, fmt.Errorf("%s_TARGET_IP: %w", key, err)
 		}
+		target = targetIP
 	}
-
-	if c.cfg.EnableTraefikPoll {
-		addToMappings(mappings, c.checkTraefik(ctx, logger))
+	// TXT content usually differs per host, so it may come from the
+	// cloudflare.companion.content label alone.
+	if strings.TrimSpace(target) == "" && rcType != "TXT" {
+		return DomainConfig{}, fmt.Errorf("%s has no content for %s records: set %s_TARGET_DOMAIN or TARGET_DOMAIN", key, rcType, key)
 	}
-
-	return mappings, nil
-}
-
-func (c *Companion) RunTraefikPoller(ctx context.Context, logger *Logger) {
-	ticker := time.NewTicker(time.Duration(c.cfg.TraefikPollSecs) * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			runWithRecover(logger, "traefik-poller", func() {
-				c.SyncMappings(c.checkTraefik(ctx, logger), logger)
-			})
+	if target != "" {
+		if err := validateTargetList(rcType, target); err != nil {
+			return DomainConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	mxPriority := parseIntOr(get("_MX_PRIORITY"), 10)
+	if mxPriority < 0 || mxPriority > 65535 {
+		return DomainConfig{}, fmt.Errorf("%s_MX_PRIORITY must be between 0 and 65535", key)
+	}
+	apexType := strings.ToUpper(strings.TrimSpace(get("_APEX_RC_TYPE")))
+	apexTarget := ""
+	if apexType != "" {
+		apexTarget = defaultString(get("_APEX_TARGET_DOMAIN"), target)
+		if !slices.Contains(supportedRecordTypes, apexType) {
+			return DomainConfig{}, fmt.Errorf("%s_APEX_RC_TYPE must be one of %s, got %q", key, strings.Join(supportedRecordTypes, ", "), apexType)
+		}
+		if err := validateTargetList(apexType, apexTarget); err != nil {
+			return DomainConfig{}, fmt.Errorf("%s apex: %w", key, err)
 		}
 	}
+	return DomainConfig{
+		Name:                     get(""),
+		RecordType:               rcType,
+		Proxied:                  parseBoolLikePython(get("_PROXIED"), false),
+		ZoneID:                   zone,
+		TTL:                      ttl,
+		TargetDomain:             target,
+		Comment:                  get("_COMMENT"),
+		CloudflareToken:          get("_CF_TOKEN"),
+		ApexRecordType:           apexType,
+		ApexTargetDomain:         apexTarget,
+		MXPriority:               mxPriority,
+		ExcludedSubDomains:       excluded,
+		ExcludedSubDomainRegexes: excludedRegexes,
+	}, nil
+}
+
+// validateTargetList validates every target of a comma separated list. A
+// name holds a single CNAME, so CNAME records take exactly one target.
+func validateTargetList(recordType string, target string) error {
+	targets := splitTargets(recordType, target)
+	if len(targets) > 1 && recordType == "CNAME" {
+		return fmt.Errorf("CNAME records take a single target, got %d", len(targets))
+	}
+	for _, target := range targets {
+		if err := validateRecordContent(recordType, target, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var targetTemplatePlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+func validateTargetTemplate(template string) error {
+	for _, placeholder := range targetTemplatePlaceholder.FindAllString(template, -1) {
+		if placeholder != "{host}" && placeholder != "{subdomain}" && placeholder != "{domain}" {
+			return fmt.Errorf("unknown placeholder %s, use {host}, {subdomain} or {domain}", placeholder)
+		}
+	}
+	return nil
+}
+
+// expandTargetTemplate computes the CNAME target of host from TARGET_TEMPLATE,
+// filling {host}, {domain} and {subdomain}, the part of host in front of the
+// domain. It returns "" to keep the static target: for other record types,
+// for the apex when {subdomain} is used, and when host would point to itself.
+func expandTargetTemplate(template string, host string, dom DomainConfig) string {
+	if template == "" || dom.RecordType != "CNAME" {
+		return ""
+	}
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(host, "."), strings.TrimSuffix(dom.Name, ".")), ".")
+	if subdomain == "" && strings.Contains(template, "{subdomain}") {
+		return ""
+	}
+	target := strings.NewReplacer(
+		"{host}", host,
+		"{subdomain}", subdomain,
+		"{domain}", dom.Name,
+	).Replace(template)
+	if strings.EqualFold(target, host) {
+		return ""
+	}
+	return target
+}
+
+// expandComment fills the {host}, {target}, {date} and {source} placeholders
+// of a DOMAINn_COMMENT template.
+func expandComment(template string, host string, target string, source int, now time.Time) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	return strings.NewReplacer(
+		"{host}", host,
+		"{target}", target,
+		"{date}", now.UTC().Format(time.DateOnly),
+		"{source}", sourceName(source),
+	).Replace(template)
+}
+
+func sourceName(source int) string {
+	switch source {
+	case 1:
+		return "docker"
+	case 2:
+		return "traefik"
+	}
+	return strconv.Itoa(source)
+}
+
+func recordTypeForContent(content string) string {
+	addr, err := netip.ParseAddr(content)
+	switch {
+	case err != nil:
+		return "CNAME"
+	case addr.Is4():
+		return "A"
+	default:
+		return "AAAA"
+	}
+}
+
+func profileDefined(profile string) bool {
+	prefix := "PROFILE_" + profile + "_"
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var dockerAPIVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+var supportedRecordTypes = []string{"CNAME", "A", "AAAA", "TXT", "MX", "SRV"}
+
+// proxyableRecordTypes are the record types Cloudflare can proxy; PROXIED is
+// ignored for the others.
+var proxyableRecordTypes = []string{"CNAME", "A", "AAAA"}
+
+// maxTXTLength is the longest TXT content Cloudflare accepts.
+const maxTXTLength = 2048
+
+func validateRecordContent(recordType string, content string, strict bool) error {
+	switch recordType {
+	case "CNAME":
+		if !strict {
+			return nil
+		}
+		if _, err := netip.ParseAddr(content); err == nil {
+			return fmt.Errorf("CNAME record content %q is an IP address, not a hostname", content)
+		}
+		if !isValidHostname(content) {
+			return fmt.Errorf("CNAME record content %q is not a valid hostname", content)
+		}
+	case "A":
+		addr, err := netip.ParseAddr(content)
+		if err != nil || !addr.Is4() {
+			return fmt.Errorf("A record content %q is not an IPv4 address", content)
+		}
+	case "AAAA":
+		addr, err := netip.ParseAddr(content)
+		if err != nil || !addr.Is6() {
+			return fmt.Errorf("AAAA record content %q is not an IPv6 address", content)
+		}
+	case "MX", "SRV":
+		if _, err := netip.ParseAddr(content); err == nil || !isValidHostname(content) {
+			return fmt.Errorf("%s record target %q is not a hostname", recordType, content)
+		}
+	case "TXT":
+		if content == "" {
+			return errors.New("TXT record content is empty")
+		}
+		if len(content) > maxTXTLength {
+			return fmt.Errorf("TXT record content is longer than %d characters", maxTXTLength)
+		}
+	}
+	return nil
+}
+
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+func isValidHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadTraefikHostFilters(configDir string, requireExplicitIncludes bool, strictIncludes bool) ([]*regexp.Regexp, []*regexp.Regexp, error) {
+	rInc := regexp.MustCompile(`(?i)^TRAEFIK_INCLUDED_HOST[0-9]+$`)
+	rExc := regexp.MustCompile(`(?i)^TRAEFIK_EXCLUDED_HOST[0-9]+$`)
+
+	includes := make([]*regexp.Regexp, 0)
+	excludes := make([]*regexp.Regexp, 0)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		name := parts[0]
+		val := ""
+		if len(parts) == 2 {
+			val = parts[1]
+		}
+		if rInc.MatchString(name) {
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s regex: %w", name, err)
+			}
+			includes = append(includes, re)
+		}
+		if rExc.MatchString(name) {
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s regex: %w", name, err)
+			}
+			excludes = append(excludes, re)
+		}
+	}
+
+	if configDir != "" {
+		fileIncludes, err := loadHostFilterFile(filepath.Join(configDir, "included_hosts"))
+		if err != nil {
+			return nil, nil, err
+		}
+		fileExcludes, err := loadHostFilterFile(filepath.Join(configDir, "excluded_hosts"))
+		if err != nil {
+			return nil, nil, err
+		}
+		includes = append(includes, fileIncludes...)
+		excludes = append(excludes, fileExcludes...)
+	}
+
+	if len(includes) == 0 {
+		if requireExplicitIncludes {
+			return nil, nil, errors.New("TRAEFIK_REQUIRE_EXPLICIT_INCLUDES is set but no TRAEFIK_INCLUDED_HOSTn is defined")
+		}
+		if strictIncludes {
+			return includes, excludes, nil
+		}
+		includes = append(includes, regexp.MustCompile(`.*`))
+	}
+
+	return includes, excludes, nil
+}
+
+func newCloudflareClient(cfg Config, email string, token string, metrics *requestMetrics, logger *Logger) (*CloudflareAPI, error) {
+	cf, err := NewCloudflareAPI(email, token, cloudflareAPIURL(cfg.CloudflareAPIBase, cfg.CloudflareAPIVersion), logger)
+	if err != nil {
+		return nil, err
+	}
+	cf.SetRequestTimeout(time.Duration(cfg.CloudflareRequestTimeoutSecs) * time.Second)
+	cf.SetTransport(newCloudflareTransport(cfg.CloudflareMaxIdleConnsPerHost, time.Duration(cfg.CloudflareKeepAliveSecs)*time.Second))
+	cf.SetRateLimit(cfg.CloudflareRateLimitPerMinute)
+	cf.SetMetrics(metrics)
+	cf.SetUserAgent(cfg.UserAgent)
+	return cf, nil
+}
+
+// cloudflareFor returns the client for the domain's own DOMAINn_CF_TOKEN,
+// falling back to the global CF_TOKEN client.
+func (c *Companion) cloudflareFor(dom DomainConfig) *CloudflareAPI {
+	if cf, ok := c.zoneCF[dom.CloudflareToken]; ok {
+		return cf
+	}
+	return c.cf
+}
+
+func (c *Companion) CheckDNSSEC(logger *Logger) {
+	c.dnssec = map[string]bool{}
+	for _, dom := range c.cfg.Domains {
+		status, err := c.cloudflareFor(dom).GetDNSSECStatus(context.Background(), dom.ZoneID)
+		if err != nil {
+			logger.Errorf("failed to get dnssec status for %s: %v", dom.Name, err)
+			continue
+		}
+		logger.Debugf("Domain %s DNSSEC status: %s", dom.Name, status)
+		active := status == "active"
+		c.dnssec[dom.ZoneID] = active
+		if active && dom.Proxied {
+			logger.Warnf("Domain %s has DNSSEC enabled and is configured with proxied records, which may not resolve as expected", dom.Name)
+		}
+	}
+}
+
+// validateTargets resolves the CNAME targets the domains write. Domains
+// inherit TARGET_DOMAIN when they do not set their own, so the global target
+// is only checked when a domain uses it.
+func validateTargets(ctx context.Context, cfg Config, timeout time.Duration, lookup func(ctx context.Context, host string) ([]string, error)) error {
+	var targets []string
+	for _, dom := range cfg.Domains {
+		if dom.RecordType != "CNAME" {
+			continue
+		}
+		targets = append(targets, splitTargets(dom.RecordType, dom.TargetDomain)...)
+	}
+
+	seen := map[string]bool{}
+	var errs []error
+	for _, target := range targets {
+		if target == "" || seen[target] || net.ParseIP(target) != nil {
+			continue
+		}
+		seen[target] = true
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		_, err := lookup(lookupCtx, target)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s does not resolve: %w", target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Companion) GetInitialMappings(ctx context.Context, logger *Logger) (map[string]Mapping, error) {
+	mappings := map[string]Mapping{}
+
+	if c.cfg.EnableDockerPoll {
+		listOpts := container.ListOptions{}
+		if c.cfg.DockerListLabelFilter != "" {
+			listOpts.Filters = filters.NewArgs(filters.Arg("label", c.cfg.DockerListLabelFilter))
+		}
+		containers, err := c.docker.ContainerList(ctx, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, json := range c.inspectContainers(ctx, containers, logger) {
+			addToMappings(mappings, c.containerMappings(json, logger))
+		}
+	}
+
+	if c.cfg.DockerSwarmMode {
+		services, err := c.docker.ServiceList(ctx, swarm.ServiceListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range services {
+			if c.isServiceStopped(svc) {
+				logger.Verbosef("Skip Service ID: %s scaled to zero replicas", svc.ID)
+				continue
+			}
+			if c.cfg.TraefikVersion == "1" {
+				if svc.Spec.TaskTemplate.ContainerSpec != nil {
+					addToMappings(mappings, c.trackService(svc.ID, c.checkServiceT1(svc.ID, svc.Spec.TaskTemplate.ContainerSpec.Labels, logger)))
+				}
+			} else {
+				addToMappings(mappings, c.trackService(svc.ID, c.checkServiceT2(svc.ID, svc.Spec.Labels, serviceContainerLabels(svc), logger)))
+			}
+		}
+	}
+
+	if c.cfg.EnableTraefikPoll {
+		traefikMappings, _ := c.checkTraefik(ctx, logger)
+		addToMappings(mappings, traefikMappings)
+	}
+
+	return mappings, nil
+}
+
+// inspectContainers inspects containers with up to DOCKER_INSPECT_CONCURRENCY
+// parallel requests, keeping the list order and dropping failed inspects.
+func (c *Companion) inspectContainers(ctx context.Context, containers []container.Summary, logger *Logger) []container.InspectResponse {
+	results := make([]*container.InspectResponse, len(containers))
+	sem := make(chan struct{}, max(c.cfg.DockerInspectConcurrency, 1))
+	wg := &sync.WaitGroup{}
+	for i, ctr := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			json, err := c.docker.ContainerInspect(ctx, ctr.ID)
+			if err != nil {
+				logger.Debugf("failed to inspect container %s: %v", ctr.ID, err)
+				return
+			}
+			results[i] = &json
+		}()
+	}
+	wg.Wait()
+
+	inspected := make([]container.InspectResponse, 0, len(containers))
+	for _, json := range results {
+		if json != nil {
+			inspected = append(inspected, *json)
+		}
+	}
+	return inspected
+}
+
+func (c *Companion) RunTraefikPoller(ctx context.Context, logger *Logger) {
+	minInterval := time.Duration(c.cfg.TraefikPollMinSecs) * time.Second
+	maxInterval := time.Duration(c.cfg.TraefikPollMaxSecs) * time.Second
+	interval := min(max(time.Duration(c.cfg.TraefikPollSecs)*time.Second, minInterval), maxInterval)
+
+	var previous map[string]Mapping
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			runWithRecover(logger, "traefik-poller", func() {
+				var changed bool
+				previous, changed = c.traefikPollCycle(ctx, previous, logger)
+				next := nextPollInterval(interval, changed, minInterval, maxInterval)
+				if next != interval {
+					logger.Debugf("Traefik poll interval adjusted from %s to %s", interval, next)
+					interval = next
+				}
+			})
+			timer.Reset(interval)
+		}
+	}
+}
+
+// traefikPollCycle polls Traefik and syncs its hosts, returning them and
+// whether they changed since previous. A skipped or failed poll returns
+// previous as unchanged, so the interval backs off while Traefik is
+// unhealthy.
+func (c *Companion) traefikPollCycle(ctx context.Context, previous map[string]Mapping, logger *Logger) (map[string]Mapping, bool) {
+	mappings, ok := c.pollTraefik(ctx, logger)
+	if !ok {
+		return previous, false
+	}
+	c.queueSync(ctx, "Traefik poll", mappings, logger)
+	added, removed := hostDelta(previous, mappings)
+	logger.Infof("Traefik poll: %d hosts, %d added, %d removed", len(mappings), len(added), len(removed))
+	if len(added) > 0 || len(removed) > 0 {
+		logger.Debugf("Traefik poll added %v, removed %v", added, removed)
+	}
+	return mappings, previous != nil && (len(added) > 0 || len(removed) > 0)
+}
+
+// pollTraefik reports false when the poll was skipped or failed.
+func (c *Companion) pollTraefik(ctx context.Context, logger *Logger) (map[string]Mapping, bool) {
+	if c.toggles.Paused(toggleTraefik) {
+		logger.Verbosef("Traefik discovery paused, skipping poll")
+		return nil, false
+	}
+	return c.checkTraefik(ctx, logger)
+}
+
+// traefikStartupBackoff is the first delay between Traefik readiness checks,
+// doubled up to traefikStartupMaxBackoff.
+const (
+	traefikStartupBackoff    = 500 * time.Millisecond
+	traefikStartupMaxBackoff = 10 * time.Second
+)
+
+// waitForTraefik retries the Traefik API with backoff until it answers or
+// TRAEFIK_STARTUP_TIMEOUT_SECONDS pass, so a companion started together with
+// Traefik does not miss its routers until the next poll. It reports whether
+// Traefik became ready.
+func (c *Companion) waitForTraefik(ctx context.Context, backoff time.Duration, logger *Logger) bool {
+	timeout := time.Duration(c.cfg.TraefikStartupTimeoutSecs) * time.Second
+	deadline := time.Now().Add(timeout)
+	for {
+		_, statusCode, _, err := FetchTraefikRoutersWithOptions(ctx, c.cfg.TraefikPollURL, c.cfg.TraefikAPIPath, c.traefikClientOptions())
+		if err == nil && statusCode == http.StatusOK {
+			return true
+		}
+		if err == nil {
+			err = fmt.Errorf("status %d", statusCode)
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			logger.Warnf("Traefik API not ready after %s, continuing without waiting: %v", timeout, err)
+			return false
+		}
+		logger.Verbosef("Traefik API not ready, retrying in %s: %v", min(backoff, remaining), err)
+		if !sleepContext(ctx, min(backoff, remaining)) {
+			return false
+		}
+		backoff = min(backoff*2, traefikStartupMaxBackoff)
+	}
+}
+
+func nextPollInterval(current time.Duration, changed bool, minInterval, maxInterval time.Duration) time.Duration {
+	if changed {
+		return minInterval
+	}
+	return min(current*2, maxInterval)
+}
+
+// nextReconnectDelay doubles the Docker event watcher reconnect delay, capped
+// at maxDelay.
+func nextReconnectDelay(current, minDelay, maxDelay time.Duration) time.Duration {
+	return min(max(current*2, minDelay), maxDelay)
+}
+
+// hostDelta returns the sorted hosts of current missing from previous and
+// the hosts of previous missing from current.
+func hostDelta(previous, current map[string]Mapping) ([]string, []string) {
+	var added, removed []string
+	for host := range current {
+		if _, ok := previous[host]; !ok {
+			added = append(added, host)
+		}
+	}
+	for host := range previous {
+		if _, ok := current[host]; !ok {
+			removed = append(removed, host)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
 }
 
 func (c *Companion) RunDockerEventWatch(ctx context.Context, logger *Logger) {
+	minDelay := time.Duration(c.cfg.DockerReconnectMinSecs) * time.Second
+	maxDelay := time.Duration(c.cfg.DockerReconnectMaxSecs) * time.Second
+	delay := minDelay
 	since := strconv.FormatInt(time.Now().Unix(), 10)
 	for {
 		if ctx.Err() != nil {
@@ -385,18 +1584,21 @@ func (c *Companion) RunDockerEventWatch(ctx context.Context, logger *Logger) {
 				return
 			case err := <-errCh:
 				if err != nil && !errors.Is(err, context.Canceled) {
-					logger.Errorf("docker event watcher error: %v", err)
-					time.Sleep(2 * time.Second)
+					logger.Errorf("docker event watcher error, reconnecting in %s: %v", delay, err)
+					if !sleepContext(ctx, delay) {
+						return
+					}
+					delay = nextReconnectDelay(delay, minDelay, maxDelay)
 				}
 				goto reconnect
 			case ev, ok := <-eventCh:
 				if !ok {
 					goto reconnect
 				}
+				delay = minDelay
 				runWithRecover(logger, "docker-event-watch", func() {
 					since = strconv.FormatInt(ev.Time, 10)
-					newMappings := c.processDockerEvent(ctx, ev, logger)
-					c.SyncMappings(newMappings, logger)
+					c.handleDockerEvent(ctx, ev, logger)
 				})
 			}
 		}
@@ -404,8 +1606,32 @@ func (c *Companion) RunDockerEventWatch(ctx context.Context, logger *Logger) {
 	}
 }
 
-func (c *Companion) processDockerEvent(ctx context.Context, event events.Message, logger *Logger) map[string]int {
-	newMappings := map[string]int{}
+func (c *Companion) handleDockerEvent(ctx context.Context, event events.Message, logger *Logger) {
+	if c.toggles.Paused(toggleDocker) {
+		logger.Verbosef("Docker discovery paused, skipping %s %s event", event.Type, event.Action)
+		return
+	}
+	c.queueSync(ctx, "Docker event", c.processDockerEvent(ctx, event, logger), logger)
+}
+
+func (c *Companion) WatchToggleSignal(ctx context.Context, logger *Logger) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			paused := !c.toggles.Paused(toggleCloudflare)
+			c.toggles.SetPaused(toggleCloudflare, paused)
+			logger.Infof("Runtime toggle %s enabled=%v", toggleCloudflare, !paused)
+		}
+	}
+}
+
+func (c *Companion) processDockerEvent(ctx context.Context, event events.Message, logger *Logger) map[string]Mapping {
+	newMappings := map[string]Mapping{}
 	evtType := event.Type
 	evtAction := string(event.Action)
 
@@ -417,250 +1643,1082 @@ func (c *Companion) processDockerEvent(ctx context.Context, event events.Message
 		}
 		json, err := c.docker.ContainerInspect(ctx, contID)
 		if err == nil {
-			if c.cfg.TraefikVersion == "1" {
-				addToMappings(newMappings, c.checkContainerT1(json.ID, json.Config.Labels, logger))
-			} else {
-				addToMappings(newMappings, c.checkContainerT2(json.ID, json.Config.Labels, logger))
+			addToMappings(newMappings, c.containerMappings(json, logger))
+		}
+	}
+
+	if c.cfg.DockerSwarmMode && evtType == events.ServiceEventType {
+		switch evtAction {
+		case "create", "update":
+			addToMappings(newMappings, c.serviceEventMappings(ctx, event.Actor.ID, evtAction, logger))
+		case "remove":
+			c.removeService(ctx, event.Actor.ID, logger)
+		}
+	}
+
+	return newMappings
+}
+
+func (c *Companion) serviceEventMappings(ctx context.Context, id string, action string, logger *Logger) map[string]Mapping {
+	if id == "" {
+		logger.Debugf("Skip service %s event without Actor.ID", action)
+		return nil
+	}
+	svc, _, err := c.docker.ServiceInspectWithRaw(ctx, id, swarm.ServiceInspectOptions{})
+	if err != nil {
+		logger.Errorf("failed to inspect service %s on %s event: %v", id, action, err)
+		return nil
+	}
+	// A service scaled to zero replicas keeps its labels, so it goes
+	// through the same path as a removed one.
+	if c.isServiceStopped(svc) {
+		logger.Verbosef("Service ID: %s scaled to zero replicas on %s event", id, action)
+		c.removeService(ctx, id, logger)
+		return nil
+	}
+	if c.cfg.TraefikVersion == "1" {
+		if svc.Spec.TaskTemplate.ContainerSpec == nil {
+			return nil
+		}
+		return c.trackService(id, c.checkServiceT1(id, svc.Spec.TaskTemplate.ContainerSpec.Labels, logger))
+	}
+	return c.trackService(id, c.checkServiceT2(id, svc.Spec.Labels, serviceContainerLabels(svc), logger))
+}
+
+// trackService remembers the hosts of a swarm service, so their records can
+// be deleted once the service is removed.
+func (c *Companion) trackService(id string, mappings map[string]Mapping) map[string]Mapping {
+	c.servicesM.Lock()
+	defer c.servicesM.Unlock()
+	if c.services == nil {
+		c.services = map[string]map[string]Mapping{}
+	}
+	c.services[id] = mappings
+	return mappings
+}
+
+// removeService deletes the records of the hosts of a removed swarm service
+// that no other known service still routes.
+func (c *Companion) removeService(ctx context.Context, id string, logger *Logger) {
+	c.servicesM.Lock()
+	hosts := maps.Clone(c.services[id])
+	delete(c.services, id)
+	for _, other := range c.services {
+		for host := range other {
+			delete(hosts, host)
+		}
+	}
+	c.servicesM.Unlock()
+	if len(hosts) == 0 {
+		logger.Debugf("Service ID: %s removed without hosts to delete", id)
+		return
+	}
+	if c.toggles.Paused(toggleCloudflare) {
+		logger.Verbosef("Cloudflare writes paused, keeping the records of removed Service ID: %s", id)
+		return
+	}
+	apiCtx, stop := c.drainContext(ctx)
+	defer stop()
+	for _, name := range slices.Sorted(maps.Keys(hosts)) {
+		logger.Verbosef("Service ID: %s removed, deleting the records of %s", id, name)
+		c.deleteHost(apiCtx, name, hosts[name], logger)
+	}
+}
+
+func (c *Companion) containerMappings(json container.InspectResponse, logger *Logger) map[string]Mapping {
+	if json.Config == nil {
+		return map[string]Mapping{}
+	}
+	if !c.isOnFilteredNetwork(json) {
+		logger.Verbosef("Skip Container ID: %s not attached to network %s", json.ID, c.cfg.DockerNetworkFilter)
+		return map[string]Mapping{}
+	}
+	if c.cfg.TraefikVersion == "1" {
+		return c.checkContainerT1(json.ID, json.Config.Labels, logger)
+	}
+	return c.checkContainerT2(json.ID, json.Config.Labels, logger)
+}
+
+func (c *Companion) isOnFilteredNetwork(json container.InspectResponse) bool {
+	if c.cfg.DockerNetworkFilter == "" {
+		return true
+	}
+	if json.NetworkSettings == nil {
+		return false
+	}
+	_, ok := json.NetworkSettings.Networks[c.cfg.DockerNetworkFilter]
+	return ok
+}
+
+// isServiceStopped reports whether a replicated swarm service is scaled to
+// zero replicas. The spec is used rather than the running tasks, which drop
+// to zero for a moment during a rolling update.
+func (c *Companion) isServiceStopped(svc swarm.Service) bool {
+	if !c.cfg.DockerSwarmIgnoreStopped {
+		return false
+	}
+	replicated := svc.Spec.Mode.Replicated
+	return replicated != nil && replicated.Replicas != nil && *replicated.Replicas == 0
+}
+
+func (c *Companion) checkContainerT1(id string, labels map[string]string, logger *Logger) map[string]Mapping {
+	mappings := map[string]Mapping{}
+	if c.isIgnored(labels) || !c.isTraefikEnabled(labels) || !c.matchTraefikFilter(labels) {
+		return mappings
+	}
+	for key, value := range labels {
+		if regexp.MustCompile(`traefik.*.frontend.rule`).MatchString(key) {
+			for _, host := range parseTraefikV1HostRule(value) {
+				if !c.isHostAllowed(host) {
+					logger.Verbosef("Ignoring Container ID: %s Hostname %s because of host filters", id, host)
+					continue
+				}
+				logger.Verbosef("Found Container ID: %s with Hostname %s", id, host)
+				mappings[host] = labelMapping(labels, "Container ID: "+id, logger)
+			}
+		}
+	}
+	return c.limitHosts("Container ID: "+id, mappings, logger)
+}
+
+func (c *Companion) checkServiceT1(id string, labels map[string]string, logger *Logger) map[string]Mapping {
+	mappings := map[string]Mapping{}
+	if c.isIgnored(labels) || !c.isTraefikEnabled(labels) || !c.matchTraefikFilter(labels) {
+		return mappings
+	}
+	for key, value := range labels {
+		if regexp.MustCompile(`traefik.*.frontend.rule`).MatchString(key) {
+			for _, host := range parseTraefikV1HostRule(value) {
+				if !c.isHostAllowed(host) {
+					logger.Verbosef("Ignoring Service ID: %s Hostname %s because of host filters", id, host)
+					continue
+				}
+				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
+				mappings[host] = labelMapping(labels, "Service ID: "+id, logger)
+			}
+		}
+	}
+	return c.limitHosts("Service ID: "+id, mappings, logger)
+}
+
+func (c *Companion) checkContainerT2(id string, labels map[string]string, logger *Logger) map[string]Mapping {
+	mappings := map[string]Mapping{}
+	if c.isIgnored(labels) || !c.isTraefikEnabled(labels) || !c.matchTraefikFilter(labels) {
+		return mappings
+	}
+	for key, value := range labels {
+		if regexp.MustCompile(`traefik.*?\.rule`).MatchString(key) && strings.Contains(value, "Host") {
+			for _, host := range parseTraefikV2Rule(value) {
+				if !c.isHostAllowed(host) {
+					logger.Verbosef("Ignoring Container ID: %s Hostname %s because of host filters", id, host)
+					continue
+				}
+				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
+				mappings[host] = labelMapping(labels, "Container ID: "+id, logger)
+			}
+		}
+	}
+	return c.limitHosts("Container ID: "+id, mappings, logger)
+}
+
+// checkServiceT2 maps the router rules in a service's labels. The
+// TRAEFIK_FILTER may also match containerLabels, the labels of the task
+// template, as stacks often set it on the container rather than the service.
+func (c *Companion) checkServiceT2(id string, labels map[string]string, containerLabels map[string]string, logger *Logger) map[string]Mapping {
+	mappings := map[string]Mapping{}
+	if c.isIgnored(labels) || !c.isTraefikEnabled(labels) || (!c.matchTraefikFilter(labels) && !c.matchTraefikFilter(containerLabels)) {
+		return mappings
+	}
+	for key, value := range labels {
+		if regexp.MustCompile(`traefik.*?\.rule`).MatchString(key) && strings.Contains(value, "Host") {
+			for _, host := range parseTraefikV2Rule(value) {
+				if !c.isHostAllowed(host) {
+					logger.Verbosef("Ignoring Service ID: %s Hostname %s because of host filters", id, host)
+					continue
+				}
+				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
+				mappings[host] = labelMapping(labels, "Service ID: "+id, logger)
+			}
+		}
+	}
+	return c.limitHosts("Service ID: "+id, mappings, logger)
+}
+
+func (c *Companion) traefikClientOptions() TraefikClientOptions {
+	return TraefikClientOptions{
+		Headers:            c.cfg.TraefikPollHeaders,
+		InsecureSkipVerify: c.cfg.TraefikPollInsecureSkipVerify,
+		CACertFile:         c.cfg.TraefikPollCACertFile,
+		Client:             c.traefikClient,
+	}
+}
+
+// checkTraefik returns the hosts of the Traefik routers. It reports false
+// when the routers could not be listed, as an empty result would otherwise
+// read as every host being removed.
+func (c *Companion) checkTraefik(ctx context.Context, logger *Logger) (map[string]Mapping, bool) {
+	mappings := map[string]Mapping{}
+	logger.Verbosef("Querying Traefik routers from %s", traefikAPIURL(c.cfg.TraefikPollURL, c.cfg.TraefikAPIPath))
+	routers, statusCode, body, err := FetchTraefikRoutersWithOptions(ctx, c.cfg.TraefikPollURL, c.cfg.TraefikAPIPath, c.traefikClientOptions())
+	if err != nil {
+		logger.Errorf("failed to poll traefik routers: %v", err)
+		return mappings, false
+	}
+	if statusCode != 200 {
+		logger.Errorf("Traefik API returned error %d: %s", statusCode, body)
+		return mappings, false
+	}
+	for _, router := range routers {
+		if router.Name == "" || !c.traefikStatusIncluded(router.Status) {
+			continue
+		}
+		if len(c.cfg.TraefikProviderFilter) > 0 && !slices.Contains(c.cfg.TraefikProviderFilter, routerProvider(router.Name)) {
+			logger.Verbosef("Ignoring Traefik Router Name: %s because of its provider", router.Name)
+			continue
+		}
+		if !strings.Contains(router.Rule, "Host") {
+			continue
+		}
+		extracted := parseTraefikRouterRule(router.Rule)
+		if len(extracted) == 0 {
+			logger.Verbosef("Traefik Router Name: %s has a Host rule without parsable hostnames: %s", router.Name, router.Rule)
+			continue
+		}
+		var hosts []string
+		for _, host := range extracted {
+			if !c.isHostAllowed(host) {
+				continue
+			}
+			// Routers sharing a host, such as a canary next to its stable
+			// router, collapse into the first one so the host is synced once.
+			if _, found := mappings[host]; found {
+				logger.Verbosef("Traefik Router Name: %s shares Hostname %s with an earlier router, keeping that one", router.Name, host)
+				continue
+			}
+			hosts = append(hosts, host)
+		}
+		hosts = c.limitHostList("Traefik Router Name: "+router.Name, hosts, logger)
+		// The router detail and service are only fetched for routers with
+		// hosts left, as polls otherwise pay a request per filtered router.
+		if len(hosts) == 0 {
+			continue
+		}
+		mapping := Mapping{Source: 2}
+		if c.cfg.TraefikRouterOverrides || c.cfg.TraefikProxiedAnnotation != "" {
+			mapping = c.traefikRouterMapping(ctx, router, logger)
+		}
+		if c.cfg.TraefikUseServiceTarget {
+			mapping.Target = c.traefikServiceTarget(ctx, router, logger)
+		}
+		for _, host := range hosts {
+			logger.Verbosef("Found Traefik Router Name: %s with Hostname %s", router.Name, host)
+			mappings[host] = mapping
+		}
+	}
+	return mappings, true
+}
+
+func (c *Companion) traefikStatusIncluded(status string) bool {
+	if len(c.cfg.TraefikIncludeStatuses) == 0 {
+		return status == "enabled"
+	}
+	return slices.Contains(c.cfg.TraefikIncludeStatuses, strings.ToLower(status))
+}
+
+func (c *Companion) traefikRouterMapping(ctx context.Context, router TraefikRouter, logger *Logger) Mapping {
+	detail, err := FetchTraefikRouter(ctx, c.cfg.TraefikPollURL, c.cfg.TraefikAPIPath, router.Name, c.traefikClientOptions())
+	if err != nil {
+		logger.Errorf("failed to fetch traefik router %s: %v", router.Name, err)
+		detail = router
+	}
+	mapping := Mapping{}
+	if c.cfg.TraefikRouterOverrides {
+		mapping = routerOverrides(detail)
+	}
+	mapping.Source = 2
+	if key := c.cfg.TraefikProxiedAnnotation; key != "" {
+		proxied, ok, err := routerAnnotation(detail.Detail, key)
+		if err != nil {
+			logger.Warnf("Ignoring proxied annotation of traefik router %s: %v", router.Name, err)
+		} else if ok {
+			mapping.Proxied = &proxied
+		}
+	}
+	return mapping
+}
+
+func (c *Companion) traefikServiceTarget(ctx context.Context, router TraefikRouter, logger *Logger) string {
+	name := routerServiceName(router)
+	if name == "" {
+		return ""
+	}
+	service, err := FetchTraefikService(ctx, c.cfg.TraefikPollURL, c.cfg.TraefikAPIPath, name, c.traefikClientOptions())
+	if err != nil {
+		logger.Errorf("failed to fetch traefik service %s: %v", name, err)
+		return ""
+	}
+	target := serviceTarget(service)
+	if target == "" {
+		logger.Verbosef("Traefik service %s does not have a single server, using domain target", name)
+	}
+	return target
+}
+
+func (c *Companion) limitHosts(source string, mappings map[string]Mapping, logger *Logger) map[string]Mapping {
+	if c.cfg.MaxHostsPerSource <= 0 || len(mappings) <= c.cfg.MaxHostsPerSource {
+		return mappings
+	}
+	hosts := make([]string, 0, len(mappings))
+	for host := range mappings {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	limited := make(map[string]Mapping, c.cfg.MaxHostsPerSource)
+	for _, host := range c.limitHostList(source, hosts, logger) {
+		limited[host] = mappings[host]
+	}
+	return limited
+}
+
+func (c *Companion) limitHostList(source string, hosts []string, logger *Logger) []string {
+	if c.cfg.MaxHostsPerSource <= 0 || len(hosts) <= c.cfg.MaxHostsPerSource {
+		return hosts
+	}
+	logger.Warnf("%s contributes %d hosts, truncating to MAX_HOSTS_PER_SOURCE=%d", source, len(hosts), c.cfg.MaxHostsPerSource)
+	return hosts[:c.cfg.MaxHostsPerSource]
+}
+
+func (c *Companion) isHostAllowed(host string) bool {
+	reason := c.hostFilterReason(host)
+	if reason != "" && c.dropped != nil {
+		c.dropped[host] = reason
+	}
+	return reason == ""
+}
+
+// hostFilterReason returns which global host filter drops host, or "" if
+// none does.
+func (c *Companion) hostFilterReason(host string) string {
+	switch {
+	case !isMatching(host, c.cfg.IncludedHosts):
+		return "not matched by the include host filters"
+	case isMatching(host, c.cfg.ExcludedHosts):
+		return "matched by the exclude host filters"
+	case isMatching(host, c.excludedHostsFile.Patterns()):
+		return "matched by EXCLUDED_HOSTS_FILE"
+	}
+	return ""
+}
+
+func (c *Companion) isIgnored(labels map[string]string) bool {
+	return parseBoolLikePython(labels[c.cfg.DockerIgnoreLabel], false)
+}
+
+// isTraefikEnabled honours an explicit traefik.enable label and falls back
+// to TRAEFIK_EXPOSED_BY_DEFAULT without one.
+func (c *Companion) isTraefikEnabled(labels map[string]string) bool {
+	enable, ok := labels["traefik.enable"]
+	if !ok {
+		return c.cfg.TraefikExposedByDefault
+	}
+	return strings.EqualFold(strings.TrimSpace(enable), "true")
+}
+
+func serviceContainerLabels(svc swarm.Service) map[string]string {
+	if svc.Spec.TaskTemplate.ContainerSpec == nil {
+		return nil
+	}
+	return svc.Spec.TaskTemplate.ContainerSpec.Labels
+}
+
+func (c *Companion) matchTraefikFilter(labels map[string]string) bool {
+	if c.cfg.TraefikFilter == nil {
+		return true
+	}
+	for key, value := range labels {
+		if c.cfg.TraefikFilterKey.MatchString(key) && c.cfg.TraefikFilter.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Companion) SyncMappings(ctx context.Context, mappings map[string]Mapping, logger *Logger) SyncResult {
+	ctx = withCycleID(ctx, c.nextCycleID())
+	logger = logger.ForContext(ctx)
+	if c.toggles.Paused(toggleCloudflare) {
+		if len(mappings) > 0 {
+			logger.Verbosef("Cloudflare writes paused, skipping sync of %d hosts", len(mappings))
+		}
+		return SyncResult{}
+	}
+	if ctx.Err() != nil {
+		return SyncResult{}
+	}
+	apiCtx, stop := c.drainContext(ctx)
+	defer stop()
+	res := SyncResult{}
+	done := 0
+	for name, mapping := range mappings {
+		if ctx.Err() != nil {
+			logger.Warnf("Shutting down, skipping the remaining hosts of this sync")
+			break
+		}
+		if c.changeLimitReached(&res) {
+			logger.Errorf("Reached MAX_CHANGES_PER_RUN=%d, aborting the remaining %d hosts of this sync", c.cfg.MaxChangesPerRun, len(mappings)-done)
+			break
+		}
+		c.syncHost(apiCtx, name, mapping, &res, logger)
+		done++
+	}
+	return res
+}
+
+// nextCycleID returns the ID tagging the log lines of a sync cycle.
+func (c *Companion) nextCycleID() string {
+	if c.cycleID != nil {
+		return c.cycleID()
+	}
+	return fmt.Sprintf("%06x", rand.Uint32()&0xffffff)
+}
+
+var errChangeLimitReached = errors.New("MAX_CHANGES_PER_RUN reached")
+
+// changeLimitReached reports whether the sync of res made the most record
+// writes MAX_CHANGES_PER_RUN allows, guarding against a misconfiguration
+// rewriting every record at once.
+func (c *Companion) changeLimitReached(res *SyncResult) bool {
+	c.resM.Lock()
+	defer c.resM.Unlock()
+	return c.cfg.MaxChangesPerRun > 0 && res.changes() >= c.cfg.MaxChangesPerRun
+}
+
+// drainContext detaches ctx from shutdown so a started host sync is not
+// interrupted mid-flight, cancelling it only when the drain context is.
+func (c *Companion) drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.drain == nil {
+		return ctx, func() {}
+	}
+	apiCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(c.drain, cancel)
+	return apiCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// syncHost holds a per-host lock from the synced check until synced is
+// updated, so concurrent syncs of a new host cannot both create a record.
+func (c *Companion) syncHost(ctx context.Context, name string, mapping Mapping, res *SyncResult, logger *Logger) {
+	lock, _ := c.hostLocks.LoadOrStore(name, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	defer c.trackSyncedMapping(name, mapping)
+
+	source := mapping.Source
+	c.syncedM.Lock()
+	current, exists := c.synced[name]
+	c.syncedM.Unlock()
+	if exists && current <= source {
+		if !verifyAll(ctx) && !c.shouldVerify() {
+			return
+		}
+		logger.Verbosef("Verifying synced record %s still exists", name)
+	}
+	if c.pointDomain(ctx, name, mapping, res, logger) {
+		c.syncedM.Lock()
+		prev, ok := c.synced[name]
+		c.synced[name] = source
+		c.syncedM.Unlock()
+		if !ok || prev != source {
+			c.saveState(logger)
+		}
+	}
+}
+
+// trackSyncedMapping keeps the mapping of name while it is synced, preferring
+// the one of the source that wrote its records.
+func (c *Companion) trackSyncedMapping(name string, mapping Mapping) {
+	c.syncedM.Lock()
+	defer c.syncedM.Unlock()
+	source, ok := c.synced[name]
+	if !ok {
+		delete(c.syncedMappings, name)
+		return
+	}
+	if c.syncedMappings == nil {
+		c.syncedMappings = map[string]Mapping{}
+	}
+	if _, known := c.syncedMappings[name]; !known || source == mapping.Source {
+		c.syncedMappings[name] = mapping
+	}
+}
+
+func logSyncResult(logger *Logger, source string, res SyncResult) {
+	if res.Changed() {
+		logger.Infof("%s sync: %s", source, res)
+		return
+	}
+	logger.Debugf("%s sync: %s", source, res)
+}
+
+func (c *Companion) record(res *SyncResult, action string, host string) {
+	c.plan.Add(action, host)
+	c.resM.Lock()
+	res.add(action)
+	c.resM.Unlock()
+}
+
+func (c *Companion) recordFailure(res *SyncResult, host string, err error) {
+	c.plan.Fail(host, err)
+	c.resM.Lock()
+	res.fail(host)
+	c.resM.Unlock()
+}
+
+// isApex reports whether name is the zone apex of dom. A CNAME cannot
+// coexist with the SOA and NS records at the apex, so Cloudflare serves it
+// flattened, resolving the target itself and answering with its addresses.
+// That works for proxied records but may be rejected for an unproxied CNAME
+// to an external host, which is why DOMAINn_APEX_RC_TYPE can switch the apex
+// to A/AAAA records.
+func isApex(name string, dom DomainConfig) bool {
+	return strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(dom.Name, "."))
+}
+
+func (c *Companion) shouldVerify() bool {
+	if c.cfg.VerifySampleRate <= 0 || c.sample == nil {
+		return false
+	}
+	return c.sample() < c.cfg.VerifySampleRate
+}
+
+func (c *Companion) pointDomain(ctx context.Context, name string, mapping Mapping, res *SyncResult, logger *Logger) bool {
+	logger = logger.With("host", name, "source", sourceName(mapping.Source))
+	if mapping.ZoneID != "" && !c.zoneConfigured(mapping.ZoneID) {
+		err := fmt.Errorf("%s label pins zone %s, which no configured domain uses", labelZone, mapping.ZoneID)
+		logger.Errorf("%s: %v", name, err)
+		c.recordFailure(res, name, err)
+		return false
+	}
+	limit := max(c.cfg.DomainWriteConcurrency, 1)
+	if c.cfg.MaxChangesPerRun > 0 {
+		// Writing one domain at a time keeps the change limit exact.
+		limit = 1
+	}
+	var ok atomic.Bool
+	ok.Store(true)
+	sem := make(chan struct{}, limit)
+	wg := &sync.WaitGroup{}
+	for _, dom := range c.cfg.Domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !c.pointDomainConfig(ctx, name, mapping, dom, res, logger) {
+				ok.Store(false)
+			}
+		}()
+	}
+	wg.Wait()
+	return ok.Load()
+}
+
+// pointDomainConfig points name to the target of dom, if it belongs to it.
+// pointDomain runs it for up to DOMAIN_WRITE_CONCURRENCY domains at once.
+func (c *Companion) pointDomainConfig(ctx context.Context, name string, mapping Mapping, dom DomainConfig, res *SyncResult, logger *Logger) bool {
+	logger = logger.With("zone", dom.ZoneID)
+	dom = withMappingExclusions(dom, mapping)
+	if reason := c.domainSkipReason(name, mapping.ZoneID, dom); reason != "" {
+		if domainMatches(name, dom.Name) {
+			logger.Verbosef("Ignoring %s for %s because %s", name, dom.Name, reason)
+		}
+		return true
+	}
+	if c.zoneDisabled(dom.ZoneID) {
+		logger.Verbosef("Skipping %s for %s, the token is not authorized for zone %s", name, dom.Name, dom.ZoneID)
+		c.record(res, planSkip, name)
+		return false
+	}
+	if c.cfg.CustomHostnames {
+		return c.pointCustomHostname(ctx, name, dom, res, logger)
+	}
+	dom = c.domainTarget(name, mapping, dom)
+	if mapping.Content != "" {
+		if err := validateRecordContent(dom.RecordType, mapping.Content, false); err != nil {
+			logger.Errorf("%s invalid %s label: %v", name, labelContent, err)
+			c.recordFailure(res, name, err)
+			return false
+		}
+		dom.TargetDomain = mapping.Content
+	}
+	if dom.TargetDomain == "" {
+		err := fmt.Errorf("no %s record content, set the %s label", dom.RecordType, labelContent)
+		logger.Errorf("%s: %v", name, err)
+		c.recordFailure(res, name, err)
+		return false
+	}
+
+	recordName := name
+	if dom.RecordType == "SRV" {
+		err := mapping.SRVErr
+		if err == nil && mapping.SRV == nil {
+			err = fmt.Errorf("SRV records need the %s and %s labels", labelSRVService, labelSRVPort)
+		}
+		if err != nil {
+			logger.Errorf("%s: %v", name, err)
+			c.recordFailure(res, name, err)
+			return false
+		}
+		recordName = mapping.SRV.recordName(name)
+	}
+
+	targets := splitTargets(dom.RecordType, dom.TargetDomain)
+	if c.cfg.StrictTargetValidation {
+		for _, target := range targets {
+			if err := validateRecordContent(dom.RecordType, recordContent(dom.RecordType, target, mapping), true); err != nil {
+				logger.Errorf("%s refusing to write record: %v", recordName, err)
+				c.recordFailure(res, name, err)
+				return false
+			}
+		}
+	}
+
+	cf := c.cloudflareFor(dom)
+	records, err := cf.ListDNSRecords(ctx, dom.ZoneID, recordName)
+	if err != nil {
+		logger.Errorf("%s list dns records failed: %v", recordName, err)
+		c.disableZoneOnAuthError(dom, err, logger)
+		c.recordFailure(res, name, err)
+		return false
+	}
+	if len(targets) == 1 {
+		content := recordContent(dom.RecordType, dom.TargetDomain, mapping)
+		return c.pointRecord(ctx, cf, recordName, dom, mapping, managedRecords(records, dom.RecordType, content, false), false, res, logger)
+	}
+	contents := make([]string, len(targets))
+	for i, target := range targets {
+		contents[i] = recordContent(dom.RecordType, target, mapping)
+	}
+	assigned, unused := assignTargets(records, dom.RecordType, contents)
+	for _, rec := range unused {
+		logger.Warnf("%s %s record %s points to %s, which is not one of the targets %v, leaving it", name, rec.Type, rec.ID, rec.Content, targets)
+	}
+	ok := true
+	for i, target := range targets {
+		dom.TargetDomain = target
+		ok = c.pointRecord(ctx, cf, recordName, dom, mapping, assigned[i], true, res, logger) && ok
+	}
+	return ok
+}
+
+// domainTarget returns dom with the record type and target name is pointed
+// to: the target label, the apex override or TARGET_TEMPLATE, in that order.
+func (c *Companion) domainTarget(name string, mapping Mapping, dom DomainConfig) DomainConfig {
+	if mapping.Target != "" {
+		dom.TargetDomain = mapping.Target
+		dom.RecordType = recordTypeForContent(mapping.Target)
+	} else if dom.ApexRecordType != "" && isApex(name, dom) {
+		dom.TargetDomain = dom.ApexTargetDomain
+		dom.RecordType = dom.ApexRecordType
+	} else if target := expandTargetTemplate(c.cfg.TargetTemplate, name, dom); target != "" {
+		dom.TargetDomain = target
+	}
+	return dom
+}
+
+// deleteHost deletes the records a sync of name with mapping points to the
+// targets of its domains, leaving records pointing elsewhere alone, and
+// forgets that name was synced.
+func (c *Companion) deleteHost(ctx context.Context, name string, mapping Mapping, logger *Logger) {
+	lock, _ := c.hostLocks.LoadOrStore(name, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	logger = logger.With("host", name, "source", sourceName(mapping.Source))
+	if c.cfg.CustomHostnames {
+		logger.Verbosef("Keeping custom hostname %s, deleting custom hostnames is not supported", name)
+		return
+	}
+	ok := true
+	for _, dom := range c.cfg.Domains {
+		logger := logger.With("zone", dom.ZoneID)
+		dom = withMappingExclusions(dom, mapping)
+		if c.domainSkipReason(name, mapping.ZoneID, dom) != "" || c.zoneDisabled(dom.ZoneID) {
+			continue
+		}
+		dom = c.domainTarget(name, mapping, dom)
+		if mapping.Content != "" {
+			dom.TargetDomain = mapping.Content
+		}
+		recordName := name
+		if dom.RecordType == "SRV" {
+			if mapping.SRV == nil {
+				continue
 			}
+			recordName = mapping.SRV.recordName(name)
+		}
+		if dom.TargetDomain == "" {
+			continue
+		}
+		var contents []string
+		for _, target := range splitTargets(dom.RecordType, dom.TargetDomain) {
+			contents = append(contents, recordContent(dom.RecordType, target, mapping))
 		}
-	}
 
-	if c.cfg.DockerSwarmMode && evtType == events.ServiceEventType && evtAction == "update" {
-		nodeID := event.Actor.ID
-		if nodeID == "" {
-			logger.Debugf("Skip service update event without Actor.ID")
-			return newMappings
+		cf := c.cloudflareFor(dom)
+		records, err := cf.ListDNSRecords(ctx, dom.ZoneID, recordName)
+		if err != nil {
+			logger.Errorf("%s list dns records failed: %v", recordName, err)
+			c.disableZoneOnAuthError(dom, err, logger)
+			c.plan.Fail(name, err)
+			ok = false
+			continue
 		}
-		svc, _, err := c.docker.ServiceInspectWithRaw(ctx, nodeID, swarm.ServiceInspectOptions{})
-		if err == nil {
-			if c.cfg.TraefikVersion == "1" {
-				if svc.Spec.TaskTemplate.ContainerSpec != nil {
-					addToMappings(newMappings, c.checkServiceT1(nodeID, svc.Spec.TaskTemplate.ContainerSpec.Labels, logger))
-				}
+		for _, rec := range records {
+			if !strings.EqualFold(rec.Type, dom.RecordType) || !slices.ContainsFunc(contents, func(content string) bool { return sameContent(dom.RecordType, rec.Content, content) }) {
+				continue
+			}
+			if c.isProtected(rec) || c.isLocked(rec) {
+				logger.Infof("%s record %s is protected or locked, not deleting it", recordName, rec.ID)
+				continue
+			}
+			if c.cfg.DryRun {
+				logger.Infof("DRY-RUN: DELETE from Cloudflare %s, %s: %s", dom.ZoneID, rec.ID, recordName)
 			} else {
-				addToMappings(newMappings, c.checkServiceT2(nodeID, svc.Spec.Labels, logger))
+				if err := cf.DeleteDNSRecord(ctx, dom.ZoneID, rec.ID); err != nil {
+					logger.Errorf("%s delete record failed: %v", recordName, err)
+					c.disableZoneOnAuthError(dom, err, logger)
+					c.plan.Fail(name, err)
+					ok = false
+					continue
+				}
+				logger.Infof("Deleted record: %s pointing to %s", recordName, rec.Content)
+				c.notify(ctx, planDelete, dom.ZoneID, DNSRecordRequest{Type: rec.Type, Name: recordName, Content: rec.Content, Proxied: rec.Proxied}, logger)
 			}
+			c.plan.Add(planDelete, name)
 		}
 	}
-
-	return newMappings
-}
-
-func (c *Companion) checkContainerT1(id string, labels map[string]string, logger *Logger) map[string]int {
-	mappings := map[string]int{}
-	if !c.matchTraefikFilter(labels) {
-		return mappings
-	}
-	for key, value := range labels {
-		if regexp.MustCompile(`traefik.*.frontend.rule`).MatchString(key) {
-			for _, host := range parseTraefikV1HostRule(value) {
-				logger.Verbosef("Found Container ID: %s with Hostname %s", id, host)
-				mappings[host] = 1
-			}
+	if ok && !c.cfg.DryRun {
+		c.syncedM.Lock()
+		_, synced := c.synced[name]
+		delete(c.synced, name)
+		delete(c.syncedMappings, name)
+		c.syncedM.Unlock()
+		if synced {
+			c.saveState(logger)
 		}
 	}
-	return mappings
 }
 
-func (c *Companion) checkServiceT1(id string, labels map[string]string, logger *Logger) map[string]int {
-	mappings := map[string]int{}
-	if !c.matchTraefikFilter(labels) {
-		return mappings
-	}
-	for key, value := range labels {
-		if regexp.MustCompile(`traefik.*.frontend.rule`).MatchString(key) {
-			for _, host := range parseTraefikV1HostRule(value) {
-				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
-				mappings[host] = 1
-			}
+// pointRecord creates or updates the records of name pointing to
+// dom.TargetDomain. records are the listed records it manages; exact limits
+// a re-list to records with the same content, as done for multiple targets.
+func (c *Companion) pointRecord(ctx context.Context, cf *CloudflareAPI, name string, dom DomainConfig, mapping Mapping, records []DNSRecord, exact bool, res *SyncResult, logger *Logger) bool {
+	content := recordContent(dom.RecordType, dom.TargetDomain, mapping)
+	data := DNSRecordRequest{
+		Type:    dom.RecordType,
+		Name:    name,
+		Content: content,
+		TTL:     dom.TTL,
+		Proxied: dom.Proxied,
+		Comment: expandComment(dom.Comment, name, dom.TargetDomain, mapping.Source, time.Now()),
+		Tags:    c.cfg.RecordTags,
+	}
+	if mapping.Proxied != nil {
+		data.Proxied = *mapping.Proxied
+	}
+	if mapping.TTL != nil {
+		data.TTL = *mapping.TTL
+	}
+	if data.Type == "MX" {
+		priority := dom.MXPriority
+		if mapping.MXPriority != nil {
+			priority = *mapping.MXPriority
 		}
+		data.Priority = &priority
 	}
-	return mappings
-}
-
-func (c *Companion) checkContainerT2(id string, labels map[string]string, logger *Logger) map[string]int {
-	mappings := map[string]int{}
-	if !c.matchTraefikFilter(labels) {
-		return mappings
+	if data.Type == "SRV" {
+		priority := mapping.SRV.Priority
+		data.Priority = &priority
+		data.Data = mapping.SRV.data(dom.TargetDomain)
 	}
-	for key, value := range labels {
-		if regexp.MustCompile(`traefik.*?\.rule`).MatchString(key) && strings.Contains(value, "Host") {
-			for _, host := range parseTraefikV2Rule(value) {
-				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
-				mappings[host] = 1
-			}
-		}
+	if data.Proxied && !slices.Contains(proxyableRecordTypes, data.Type) {
+		logger.Debugf("%s: Cloudflare cannot proxy %s records, writing it unproxied", name, data.Type)
+		data.Proxied = false
 	}
-	return mappings
-}
 
-func (c *Companion) checkServiceT2(id string, labels map[string]string, logger *Logger) map[string]int {
-	mappings := map[string]int{}
-	if !c.matchTraefikFilter(labels) {
-		return mappings
+	if len(records) == 0 {
+		logger.Verbosef("Domain %s: Cloudflare record exists=false, configuration change required=true", name)
+	} else {
+		requiresChange := slices.ContainsFunc(records, func(rec DNSRecord) bool { return c.needsUpdate(rec, data) })
+		logger.Verbosef("Domain %s: Cloudflare record exists=true, configuration change required=%v", name, requiresChange)
 	}
-	for key, value := range labels {
-		if regexp.MustCompile(`traefik.*?\.rule`).MatchString(key) && strings.Contains(value, "Host") {
-			for _, host := range parseTraefikV2Rule(value) {
-				logger.Verbosef("Found Service ID: %s with Hostname %s", id, host)
-				mappings[host] = 1
+
+	if len(records) == 0 {
+		if c.changeLimitReached(res) {
+			logger.Errorf("%s not created: %v", name, errChangeLimitReached)
+			c.recordFailure(res, name, errChangeLimitReached)
+			return false
+		}
+		if data.Proxied && c.dnssec[dom.ZoneID] {
+			logger.Warnf("Creating proxied record %s in DNSSEC enabled zone %s", name, dom.ZoneID)
+		}
+		if data.Type == "CNAME" && !data.Proxied && isApex(name, dom) {
+			logger.Warnf("Creating unproxied CNAME at the apex of %s, which Cloudflare flattens and may reject; set the domain's PROXIED=true or APEX_RC_TYPE=A", dom.Name)
+		}
+		if c.cfg.DryRun {
+			logger.Infof("DRY-RUN: POST to Cloudflare %s: %+v", dom.ZoneID, data)
+			c.record(res, planCreate, name)
+			return true
+		}
+		created, err := cf.CreateDNSRecord(ctx, dom.ZoneID, data)
+		if err == nil {
+			logger.Infof("Created new record: %s to point to %s", name, dom.TargetDomain)
+			if created.ID == "" {
+				logger.Warnf("%s record was created but Cloudflare did not return its ID", name)
+			} else {
+				logger.Debugf("%s record ID: %s", name, created.ID)
 			}
+			c.notify(ctx, planCreate, dom.ZoneID, data, logger)
+			c.record(res, planCreate, name)
+			return true
+		}
+		var conflict *RecordConflictError
+		if !errors.As(err, &conflict) || !conflict.HasCode(cfErrRecordAlreadyExists) {
+			logger.Errorf("%s create record failed: %v", name, err)
+			c.disableZoneOnAuthError(dom, err, logger)
+			c.recordFailure(res, name, err)
+			return false
+		}
+		// The record was created out of band since it was listed, so fall
+		// through to updating the records the create found instead.
+		logger.Warnf("%s record already exists in Cloudflare, updating it instead", name)
+		records = managedRecords(conflict.Records, dom.RecordType, content, exact)
+		if len(records) == 0 {
+			logger.Warnf("%s record already exists in Cloudflare but is not listed, skipping", name)
+			c.record(res, planSkip, name)
+			return true
 		}
 	}
-	return mappings
-}
 
-func (c *Companion) checkTraefik(ctx context.Context, logger *Logger) map[string]int {
-	mappings := map[string]int{}
-	logger.Verbosef("Querying Traefik routers from %s", c.cfg.TraefikPollURL)
-	routers, statusCode, body, err := FetchTraefikRouters(
-		ctx,
-		c.cfg.TraefikPollURL,
-		c.cfg.TraefikPollInsecureSkipVerify,
-		c.cfg.TraefikPollCACertFile,
-	)
-	if err != nil {
-		logger.Errorf("failed to poll traefik routers: %v", err)
-		return mappings
-	}
-	if statusCode != 200 {
-		logger.Errorf("Traefik API returned error %d: %s", statusCode, body)
-		return mappings
-	}
-	for _, router := range routers {
-		if router.Status != "enabled" || router.Name == "" {
+	ok := true
+	for _, rec := range records {
+		if c.isProtected(rec) {
+			logger.Warnf("%s record %s points to protected content %s, not changing it", name, rec.ID, rec.Content)
+			c.record(res, planSkip, name)
 			continue
 		}
-		if !strings.Contains(router.Rule, "Host") {
+		if c.isLocked(rec) {
+			logger.Infof("%s record %s is locked by its comment %q, not changing it", name, rec.ID, rec.Comment)
+			c.record(res, planSkip, name)
 			continue
 		}
-		extracted := parseTraefikRouterRule(router.Rule)
-		for _, host := range extracted {
-			if !isMatching(host, c.cfg.IncludedHosts) {
+		data := data
+		if c.cfg.PreserveExistingProxied && mapping.Proxied == nil && slices.Contains(proxyableRecordTypes, data.Type) {
+			data.Proxied = rec.Proxied
+		}
+		if c.needsUpdate(rec, data) {
+			if c.changeLimitReached(res) {
+				logger.Errorf("%s record %s not updated: %v", name, rec.ID, errChangeLimitReached)
+				c.recordFailure(res, name, errChangeLimitReached)
+				ok = false
 				continue
 			}
-			if isMatching(host, c.cfg.ExcludedHosts) {
-				continue
+			if c.cfg.DryRun {
+				logger.Infof("DRY-RUN: PUT to Cloudflare %s, %s: %+v", dom.ZoneID, rec.ID, data)
+			} else {
+				if err := cf.UpdateDNSRecord(ctx, dom.ZoneID, rec.ID, data); err != nil {
+					logger.Errorf("%s update record failed: %v", name, err)
+					c.disableZoneOnAuthError(dom, err, logger)
+					c.recordFailure(res, name, err)
+					ok = false
+					continue
+				}
+				logger.Infof("Updated existing record: %s to point to %s", name, dom.TargetDomain)
+				c.notify(ctx, planUpdate, dom.ZoneID, data, logger)
 			}
-			logger.Verbosef("Found Traefik Router Name: %s with Hostname %s", router.Name, host)
-			mappings[host] = 2
+			c.record(res, planUpdate, name)
+		} else {
+			if rec.Proxied != data.Proxied {
+				logger.Warnf("%s record %s is proxied=%v in Cloudflare but configured proxied=%v, leaving it; set FIX_PROXIED_DRIFT=true to update it", name, rec.ID, rec.Proxied, data.Proxied)
+			}
+			logger.Verbosef("Existing record: %s already points to %s", name, dom.TargetDomain)
+			c.record(res, planSkip, name)
 		}
 	}
-	return mappings
+	return ok
 }
 
-func (c *Companion) matchTraefikFilter(labels map[string]string) bool {
-	if c.cfg.TraefikFilter == nil {
+// needsUpdate reports whether rec differs from data. Only the content, and
+// the priority of MX records, is compared unless REFRESH_ENTRIES is set,
+// which also rewrites records whose type, TTL, proxied status, comment or
+// tags changed, or FIX_PROXIED_DRIFT, which also rewrites records whose
+// proxied status changed.
+func (c *Companion) needsUpdate(rec DNSRecord, data DNSRecordRequest) bool {
+	if c.cfg.RefreshEntries {
+		return !recordMatches(rec, data)
+	}
+	if c.cfg.FixProxiedDrift && rec.Proxied != data.Proxied {
 		return true
 	}
-	for key, value := range labels {
-		if c.cfg.TraefikFilterKey.MatchString(key) && c.cfg.TraefikFilter.MatchString(value) {
-			return true
-		}
+	if data.Type == "MX" && data.Priority != nil && (rec.Priority == nil || *rec.Priority != *data.Priority) {
+		return true
 	}
-	return false
+	return !sameContent(data.Type, rec.Content, data.Content)
 }
 
-func (c *Companion) SyncMappings(mappings map[string]int, logger *Logger) {
-	for name, source := range mappings {
-		c.syncedM.Lock()
-		current, exists := c.synced[name]
-		c.syncedM.Unlock()
-		if exists && current <= source {
-			continue
-		}
-		if c.pointDomain(name, logger) {
-			c.syncedM.Lock()
-			c.synced[name] = source
-			c.syncedM.Unlock()
-		}
+// splitTargets splits a comma separated target list. TXT content is never
+// split as it may contain commas itself.
+func splitTargets(recordType string, target string) []string {
+	if recordType == "TXT" {
+		return []string{target}
+	}
+	targets := splitCleanCSV(target)
+	if len(targets) == 0 {
+		return []string{target}
 	}
+	return targets
 }
 
-func (c *Companion) pointDomain(name string, logger *Logger) bool {
-	ok := true
-	for _, dom := range c.cfg.Domains {
-		if name == dom.TargetDomain {
+// assignTargets picks the listed records of recordType managed for each
+// target: the ones already pointing to it or, for a target without any, a
+// record pointing elsewhere that gets repointed. Records left over are
+// returned as unused.
+func assignTargets(records []DNSRecord, recordType string, targets []string) ([][]DNSRecord, []DNSRecord) {
+	assigned := make([][]DNSRecord, len(targets))
+	var spare []DNSRecord
+	for _, rec := range records {
+		if !strings.EqualFold(rec.Type, recordType) {
 			continue
 		}
-		if !strings.Contains(name, dom.Name) {
+		i := slices.IndexFunc(targets, func(target string) bool { return sameContent(recordType, rec.Content, target) })
+		if i < 0 {
+			spare = append(spare, rec)
 			continue
 		}
-		if isDomainExcluded(name, dom) {
-			logger.Verbosef("Ignoring %s because it falls under excluded sub domain", name)
-			continue
+		assigned[i] = append(assigned[i], rec)
+	}
+	for i := range assigned {
+		if len(assigned[i]) == 0 && len(spare) > 0 {
+			assigned[i] = spare[:1]
+			spare = spare[1:]
 		}
+	}
+	return assigned, spare
+}
 
-		records, err := c.cf.ListDNSRecords(dom.ZoneID, name)
-		if err != nil {
-			logger.Errorf("%s list dns records failed: %v", name, err)
-			ok = false
-			continue
-		}
-		if len(records) == 0 {
-			logger.Verbosef("Domain %s: Cloudflare record exists=false, configuration change required=true", name)
-		} else {
-			requiresChange := c.cfg.RefreshEntries
-			for _, rec := range records {
-				if rec.Content != dom.TargetDomain {
-					requiresChange = true
-					break
-				}
-			}
-			logger.Verbosef("Domain %s: Cloudflare record exists=true, configuration change required=%v", name, requiresChange)
-		}
+// isProtected reports whether rec points to one of PROTECTED_CONTENTS, such
+// as a maintenance page pinned by hand, which a sync must not revert.
+func (c *Companion) isProtected(rec DNSRecord) bool {
+	return slices.ContainsFunc(c.cfg.ProtectedContents, func(content string) bool {
+		return strings.EqualFold(content, rec.Content)
+	})
+}
 
-		data := DNSRecordRequest{
-			Type:    c.cfg.RecordType,
-			Name:    name,
-			Content: dom.TargetDomain,
-			TTL:     dom.TTL,
-			Proxied: dom.Proxied,
-			Comment: dom.Comment,
-		}
+// isLocked reports whether the comment of rec contains LOCK_MARKER, which
+// operators set in the Cloudflare dashboard to keep a sync from touching it.
+func (c *Companion) isLocked(rec DNSRecord) bool {
+	return c.cfg.LockMarker != "" && strings.Contains(strings.ToLower(rec.Comment), strings.ToLower(c.cfg.LockMarker))
+}
 
-		if len(records) == 0 {
-			if c.cfg.DryRun {
-				logger.Infof("DRY-RUN: POST to Cloudflare %s: %+v", dom.ZoneID, data)
-			} else {
-				if err := c.cf.CreateDNSRecord(dom.ZoneID, data); err != nil {
-					logger.Errorf("%s create record failed: %v", name, err)
-					ok = false
-					continue
-				}
-				logger.Infof("Created new record: %s to point to %s", name, dom.TargetDomain)
-			}
-			continue
-		}
+// recordContent returns the content of a record pointing to target, which
+// for SRV records also carries the weight and port.
+func recordContent(recordType string, target string, mapping Mapping) string {
+	if recordType == "SRV" && mapping.SRV != nil {
+		return mapping.SRV.content(target)
+	}
+	return target
+}
 
-		for _, rec := range records {
-			if rec.Content != dom.TargetDomain || c.cfg.RefreshEntries {
-				if c.cfg.DryRun {
-					logger.Infof("DRY-RUN: PUT to Cloudflare %s, %s: %+v", dom.ZoneID, rec.ID, data)
-				} else {
-					if err := c.cf.UpdateDNSRecord(dom.ZoneID, rec.ID, data); err != nil {
-						logger.Errorf("%s update record failed: %v", name, err)
-						ok = false
-						continue
-					}
-					logger.Infof("Updated existing record: %s to point to %s", name, dom.TargetDomain)
-				}
-			} else {
-				logger.Verbosef("Existing record: %s already points to %s", name, dom.TargetDomain)
-			}
+// managedRecords narrows the records listed for a name to the ones a sync
+// may update. A name usually holds several TXT or MX records, so for those
+// types, or when exact is set, only the record with the same content is
+// managed and the others are left alone; a missing one is created next to
+// them.
+func managedRecords(records []DNSRecord, recordType string, content string, exact bool) []DNSRecord {
+	if !exact && recordType != "TXT" && recordType != "MX" && recordType != "SRV" {
+		return records
+	}
+	return slices.DeleteFunc(slices.Clone(records), func(rec DNSRecord) bool {
+		return !strings.EqualFold(rec.Type, recordType) || !sameContent(recordType, rec.Content, content)
+	})
+}
+
+func (c *Companion) pointCustomHostname(ctx context.Context, name string, dom DomainConfig, res *SyncResult, logger *Logger) bool {
+	cf := c.cloudflareFor(dom)
+	existing, err := cf.ListCustomHostnames(ctx, dom.ZoneID, name)
+	if err != nil {
+		logger.Errorf("%s list custom hostnames failed: %v", name, err)
+		c.recordFailure(res, name, err)
+		return false
+	}
+	if len(existing) > 0 {
+		logger.Verbosef("Custom hostname %s already exists with status %s", name, existing[0].Status)
+		c.record(res, planSkip, name)
+		return true
+	}
+
+	if c.changeLimitReached(res) {
+		logger.Errorf("%s custom hostname not created: %v", name, errChangeLimitReached)
+		c.recordFailure(res, name, errChangeLimitReached)
+		return false
+	}
+	req := CustomHostnameRequest{
+		Hostname: name,
+		SSL:      CustomHostnameSSL{Method: c.cfg.CustomHostnameSSLMethod, Type: c.cfg.CustomHostnameSSLType},
+	}
+	if c.cfg.DryRun {
+		logger.Infof("DRY-RUN: POST custom hostname to Cloudflare %s: %+v", dom.ZoneID, req)
+	} else {
+		created, err := cf.CreateCustomHostname(ctx, dom.ZoneID, req)
+		if err != nil {
+			logger.Errorf("%s create custom hostname failed: %v", name, err)
+			c.recordFailure(res, name, err)
+			return false
 		}
+		logger.Infof("Created custom hostname: %s with status %s", name, created.Status)
+	}
+	c.record(res, planCreate, name)
+	return true
+}
+
+func (c *Companion) notify(ctx context.Context, action string, zoneID string, data DNSRecordRequest, logger *Logger) {
+	if c.webhook == nil {
+		return
+	}
+	event := WebhookEvent{
+		Action:   action,
+		Hostname: data.Name,
+		Zone:     zoneID,
+		Content:  data.Content,
+		Proxied:  data.Proxied,
+	}
+	if err := c.webhook.Notify(ctx, event); err != nil {
+		logger.Warnf("%s webhook notification failed: %v", data.Name, err)
 	}
-	return ok
 }
 
-func addToMappings(current, incoming map[string]int) {
-	for host, source := range incoming {
-		if curr, ok := current[host]; !ok || curr > source {
-			current[host] = source
+// addToMappings merges incoming into current. When a host is already known
+// the mapping with the higher priority wins, on equal priority the lower
+// source (docker before traefik), and on a full tie the existing mapping is
+// kept.
+func addToMappings(current, incoming map[string]Mapping) {
+	for host, mapping := range incoming {
+		if curr, ok := current[host]; !ok || outranks(mapping, curr) {
+			current[host] = mapping
 		}
 	}
 }
 
+func outranks(a, b Mapping) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Source < b.Source
+}
+
 func parseTraefikV1HostRule(rule string) []string {
 	if !strings.Contains(rule, "Host") {
 		return nil
@@ -693,21 +2751,162 @@ func parseTraefikV2Rule(rule string) []string {
 	return out
 }
 
+var (
+	routerHostMatcher = regexp.MustCompile(`\bHost\(([^)]*)\)`)
+	routerHostArg     = regexp.MustCompile("^`([a-zA-Z0-9\\.\\-]+)`$")
+)
+
+// parseTraefikRouterRule returns the hosts of every Host() matcher in a rule,
+// including the multi-host form Host(`a`, `b`); other matchers are ignored.
 func parseTraefikRouterRule(rule string) []string {
-	rx := regexp.MustCompile(`Host\(\` + "`" + `([a-zA-Z0-9\.\-]+)\` + "`" + `\)`)
-	matches := rx.FindAllStringSubmatch(rule, -1)
-	out := make([]string, 0, len(matches))
-	for _, m := range matches {
-		if len(m) > 1 {
-			out = append(out, m[1])
+	out := make([]string, 0)
+	for _, m := range routerHostMatcher.FindAllStringSubmatch(rule, -1) {
+		for _, arg := range strings.Split(m[1], ",") {
+			if host := routerHostArg.FindStringSubmatch(strings.TrimSpace(arg)); host != nil {
+				out = append(out, host[1])
+			}
 		}
 	}
 	return out
 }
 
+const (
+	domainMatchAll           = "all"
+	domainMatchLongestSuffix = "longest-suffix"
+)
+
+func domainMatches(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// domainSkipReason returns why name is not written to dom, or "" if it is.
+func (c *Companion) domainSkipReason(name string, zone string, dom DomainConfig) string {
+	switch {
+	case slices.Contains(splitTargets(dom.RecordType, dom.TargetDomain), name):
+		return "it is the record target"
+	case zone != "" && dom.ZoneID != zone:
+		return "it is pinned to zone " + zone
+	case zone == "" && !domainMatches(name, dom.Name) && !c.isCustomHostnameIncluded(name, dom):
+		return "it is not under the domain"
+	case zone == "" && c.cfg.DomainMatchMode == domainMatchLongestSuffix && c.hasMoreSpecificDomain(name, dom):
+		return "a more specific domain matches"
+	case isDomainExcluded(name, dom):
+		return "it falls under an excluded sub domain"
+	case !isDomainHostAllowed(name, dom):
+		return "of the domain's host filters"
+	}
+	return ""
+}
+
+// isCustomHostnameIncluded reports whether name is a Cloudflare for SaaS
+// custom hostname selected by the DOMAINn_INCLUDED_HOSTm filters of dom.
+// Custom hostnames are outside the zone, so the suffix match never selects
+// them.
+func (c *Companion) isCustomHostnameIncluded(name string, dom DomainConfig) bool {
+	return c.cfg.CustomHostnames && len(dom.IncludedHosts) > 0 && isMatching(name, dom.IncludedHosts)
+}
+
+func (c *Companion) zoneConfigured(zoneID string) bool {
+	return slices.ContainsFunc(c.cfg.Domains, func(dom DomainConfig) bool { return dom.ZoneID == zoneID })
+}
+
+func (c *Companion) hasMoreSpecificDomain(host string, dom DomainConfig) bool {
+	for _, other := range c.cfg.Domains {
+		if len(other.Name) > len(dom.Name) && domainMatches(host, other.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlappingDomains returns [parent, child] pairs of configured domains
+// where every host under child also falls under parent.
+func overlappingDomains(doms []DomainConfig) [][2]string {
+	var overlaps [][2]string
+	for _, parent := range doms {
+		for _, child := range doms {
+			if len(child.Name) > len(parent.Name) && domainMatches(child.Name, parent.Name) {
+				overlaps = append(overlaps, [2]string{parent.Name, child.Name})
+			}
+		}
+	}
+	return overlaps
+}
+
+// excludedSubDomainRegexPrefix marks an excluded sub domain entry as a regex
+// matched against the full hostname, such as re:^[^.]+-internal\.
+const excludedSubDomainRegexPrefix = "re:"
+
+// parseExcludedSubDomains splits comma-separated excluded sub domains into
+// the plain entries and the compiled re: entries, returning the errors of
+// the ones that do not compile.
+func parseExcludedSubDomains(raw string) ([]string, []*regexp.Regexp, error) {
+	plain := []string{}
+	var regexes []*regexp.Regexp
+	var errs []error
+	for _, entry := range splitExcludedSubDomains(raw) {
+		pattern, ok := strings.CutPrefix(entry, excludedSubDomainRegexPrefix)
+		if !ok {
+			plain = append(plain, entry)
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid regex %q: %w", pattern, err))
+			continue
+		}
+		regexes = append(regexes, re)
+	}
+	return plain, regexes, errors.Join(errs...)
+}
+
+// splitExcludedSubDomains splits on the commas outside of brackets, braces
+// and parentheses, so a regex such as re:^a{1,3}\. stays a single entry.
+func splitExcludedSubDomains(raw string) []string {
+	var entries []string
+	depth, start := 0, 0
+	add := func(entry string) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth = max(depth-1, 0)
+		case ',':
+			if depth == 0 {
+				add(raw[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(raw[start:])
+	return entries
+}
+
+// withMappingExclusions adds the excluded sub domains of a host's labels to
+// the ones of dom.
+func withMappingExclusions(dom DomainConfig, mapping Mapping) DomainConfig {
+	if len(mapping.ExcludedSubDomains) > 0 {
+		dom.ExcludedSubDomains = append(slices.Clone(dom.ExcludedSubDomains), mapping.ExcludedSubDomains...)
+	}
+	if len(mapping.ExcludedSubDomainRegexes) > 0 {
+		dom.ExcludedSubDomainRegexes = append(slices.Clone(dom.ExcludedSubDomainRegexes), mapping.ExcludedSubDomainRegexes...)
+	}
+	return dom
+}
+
 func isDomainExcluded(name string, dom DomainConfig) bool {
+	if isMatching(name, dom.ExcludedSubDomainRegexes) {
+		return true
+	}
 	for _, sub := range dom.ExcludedSubDomains {
-		if strings.Contains(name, sub+"."+dom.Name) {
+		if domainMatches(name, sub+"."+dom.Name) {
 			return true
 		}
 	}
@@ -724,6 +2923,13 @@ func isMatching(host string, regexes []*regexp.Regexp) bool {
 }
 
 func getSecretByEnv(name string) string {
+	value, _ := resolveSecretByEnv(name)
+	return value
+}
+
+// resolveSecretByEnv resolves a secret like getSecretByEnv and also returns
+// the file it was read from, or "" when it came from the environment.
+func resolveSecretByEnv(name string) (string, string) {
 	lowerName := strings.ToLower(name)
 
 	implicitSecretPaths := make([]string, 0, len(defaultSecretDirs)*2)
@@ -737,8 +2943,8 @@ func getSecretByEnv(name string) string {
 	}
 	fileSpecs = append(fileSpecs, implicitSecretPaths...)
 	for _, spec := range fileSpecs {
-		if value := readSecretSpec(spec); value != "" {
-			return value
+		if value, path := readSecretSpec(spec); value != "" {
+			return value, path
 		}
 	}
 
@@ -749,16 +2955,16 @@ func getSecretByEnv(name string) string {
 	for _, value := range envCandidates {
 		trimmed := strings.TrimSpace(value)
 		if trimmed != "" {
-			return trimmed
+			return trimmed, ""
 		}
 	}
-	return ""
+	return "", ""
 }
 
-func readSecretSpec(spec string) string {
+func readSecretSpec(spec string) (string, string) {
 	spec = strings.TrimSpace(spec)
 	if spec == "" {
-		return ""
+		return "", ""
 	}
 
 	paths := []string{spec}
@@ -775,14 +2981,29 @@ func readSecretSpec(spec string) string {
 		}
 		value := strings.TrimSpace(string(contents))
 		if value != "" {
-			return value
+			return value, path
 		}
 	}
-	return ""
+	return "", ""
+}
+
+// dockerClientOpts pins the Docker API version to DOCKER_API_VERSION when
+// set, skipping the negotiation round trip to /_ping that locked-down
+// daemons may block, and negotiates it otherwise. The host is the one of
+// DOCKER_HOST or DOCKER_CONTEXT.
+func dockerClientOpts(cfg Config) []client.Opt {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if cfg.DockerAPIVersion != "" {
+		opts = []client.Opt{client.FromEnv, client.WithVersion(cfg.DockerAPIVersion)}
+	}
+	if cfg.DockerHost != "" {
+		opts = append(opts, client.WithHost(cfg.DockerHost))
+	}
+	return opts
 }
 
 func newDockerHTTPClient(cfg Config) (*http.Client, bool, error) {
-	dockerHost := strings.TrimSpace(os.Getenv("DOCKER_HOST"))
+	dockerHost := cfg.DockerHost
 	if dockerHost == "" {
 		dockerHost = "unix:///var/run/docker.sock"
 	}
@@ -793,11 +3014,11 @@ func newDockerHTTPClient(cfg Config) (*http.Client, bool, error) {
 	if parsed.Scheme != "tcp" && parsed.Scheme != "https" {
 		return nil, false, nil
 	}
-	if strings.TrimSpace(cfg.DockerCACertFile) == "" && !cfg.DockerInsecureSkipVerify {
+	if strings.TrimSpace(cfg.DockerCACertFile) == "" && cfg.DockerCertFile == "" && !cfg.DockerInsecureSkipVerify {
 		return nil, false, nil
 	}
 
-	tlsCfg, err := newTLSConfig(cfg.DockerCACertFile, cfg.DockerInsecureSkipVerify)
+	tlsCfg, err := newTLSConfig(cfg.DockerCACertFile, cfg.DockerCertFile, cfg.DockerKeyFile, cfg.DockerInsecureSkipVerify)
 	if err != nil {
 		return nil, false, err
 	}
@@ -809,11 +3030,24 @@ func newDockerHTTPClient(cfg Config) (*http.Client, bool, error) {
 	}, true, nil
 }
 
-func newTLSConfig(caCertFile string, insecureSkipVerify bool) (*tls.Config, error) {
+func newTLSConfig(caCertFile string, certFile string, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
 	tlsCfg := &tls.Config{
 		InsecureSkipVerify: insecureSkipVerify,
 	}
 
+	certFile = strings.TrimSpace(certFile)
+	keyFile = strings.TrimSpace(keyFile)
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client certificate and key files must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", certFile, err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
 	caCertFile = strings.TrimSpace(caCertFile)
 	if caCertFile == "" {
 		return tlsCfg, nil
@@ -861,6 +3095,22 @@ func parseBoolLikePython(raw string, defaultVal bool) bool {
 	return defaultVal
 }
 
+func validateTTL(ttl int) error {
+	if ttl != ttlAuto && (ttl < 30 || ttl > 86400) {
+		return fmt.Errorf("TTL %d must be auto or between 30 and 86400 seconds", ttl)
+	}
+	return nil
+}
+
+// parseTTL parses a TTL in seconds, accepting "auto" for Cloudflare's
+// automatic TTL.
+func parseTTL(raw string, fallback int) int {
+	if strings.EqualFold(strings.TrimSpace(raw), "auto") {
+		return ttlAuto
+	}
+	return parseIntOr(raw, fallback)
+}
+
 func parseIntOr(raw string, fallback int) int {
 	if raw == "" {
 		return fallback
@@ -872,6 +3122,17 @@ func parseIntOr(raw string, fallback int) int {
 	return v
 }
 
+func parseFloatOr(raw string, fallback float64) float64 {
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
 func defaultString(v string, fallback string) string {
 	if strings.TrimSpace(v) == "" {
 		return fallback
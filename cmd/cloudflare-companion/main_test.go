@@ -1,13 +1,106 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/require"
 )
 
+type fakeDocker struct {
+	containers []container.InspectResponse
+	services   []swarm.Service
+}
+
+func (f *fakeDocker) ContainerList(_ context.Context, options container.ListOptions) ([]container.Summary, error) {
+	out := make([]container.Summary, 0, len(f.containers))
+	for _, ctr := range f.containers {
+		if options.Filters.Len() > 0 && !options.Filters.MatchKVList("label", ctr.Config.Labels) {
+			continue
+		}
+		out = append(out, container.Summary{ID: ctr.ID})
+	}
+	return out, nil
+}
+
+func (f *fakeDocker) ContainerInspect(_ context.Context, id string) (container.InspectResponse, error) {
+	for _, ctr := range f.containers {
+		if ctr.ID == id {
+			return ctr, nil
+		}
+	}
+	return container.InspectResponse{}, os.ErrNotExist
+}
+
+func (f *fakeDocker) ServiceList(_ context.Context, _ swarm.ServiceListOptions) ([]swarm.Service, error) {
+	return f.services, nil
+}
+
+func (f *fakeDocker) ServiceInspectWithRaw(_ context.Context, id string, _ swarm.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	for _, svc := range f.services {
+		if svc.ID == id {
+			return svc, nil, nil
+		}
+	}
+	return swarm.Service{}, nil, os.ErrNotExist
+}
+
+func (f *fakeDocker) Events(_ context.Context, _ events.ListOptions) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+var matchAll = []*regexp.Regexp{regexp.MustCompile(`.*`)}
+
+func newBufferLogger(buf *bytes.Buffer) *Logger {
+	return &Logger{level: levelDebug, verbose: true, mu: &sync.Mutex{}, std: log.New(buf, "", 0)}
+}
+
+func newContainer(id string, labels map[string]string, networks ...string) container.InspectResponse {
+	ctr := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{ID: id},
+		Config:            &container.Config{Labels: labels},
+		NetworkSettings:   &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{}},
+	}
+	for _, name := range networks {
+		ctr.NetworkSettings.Networks[name] = &network.EndpointSettings{}
+	}
+	return ctr
+}
+
+func newSwarmService(id string, labels map[string]string) swarm.Service {
+	svc := swarm.Service{ID: id}
+	svc.Spec.Labels = labels
+	return svc
+}
+
 func TestParseTraefikV1HostRule(t *testing.T) {
 	hosts := parseTraefikV1HostRule("Host:example.com,www.example.com")
 	require.Equal(t, []string{"example.com", "www.example.com"}, hosts)
@@ -23,10 +116,78 @@ func TestParseTraefikRouterRule(t *testing.T) {
 	require.Equal(t, []string{"a.example.com"}, hosts)
 }
 
+func TestParseTraefikRouterRuleCommaForm(t *testing.T) {
+	require.Equal(t, []string{"a.example.com", "b.example.com"}, parseTraefikRouterRule("Host(`a.example.com`, `b.example.com`)"))
+	require.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com"},
+		parseTraefikRouterRule("(Host(`a.example.com`,`b.example.com`) && ClientIP(`10.0.0.0/8`)) || Host(`c.example.com`) && Headers(`X-Env`, `prod`)"))
+	require.Equal(t, []string{"a.example.com"}, parseTraefikRouterRule("Host(`a.example.com`) && ClientIP(`10.0.0.0/8`, `192.168.0.1`)"))
+	require.Empty(t, parseTraefikRouterRule("HostRegexp(`{sub:[a-z]+}.example.com`) || HostSNI(`tls.example.com`)"))
+}
+
 func TestIsDomainExcluded(t *testing.T) {
 	dom := DomainConfig{Name: "example.com", ExcludedSubDomains: []string{"internal", "dev"}}
 	require.True(t, isDomainExcluded("api.internal.example.com", dom))
+	require.True(t, isDomainExcluded("internal.example.com", dom))
+	require.False(t, isDomainExcluded("api.example.com", dom))
+	require.False(t, isDomainExcluded("notinternal.example.com", dom))
+	require.False(t, isDomainExcluded("internal.example.com.evil.net", dom))
+}
+
+func TestIsDomainExcludedRegex(t *testing.T) {
+	plain, regexes, err := parseExcludedSubDomains(`re:^[^.]+-internal\., lan, re:^v{1,3}\.`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"lan"}, plain)
+	require.Len(t, regexes, 2)
+	dom := DomainConfig{Name: "example.com", ExcludedSubDomains: plain, ExcludedSubDomainRegexes: regexes}
+	require.True(t, isDomainExcluded("api-internal.example.com", dom))
+	require.True(t, isDomainExcluded("db-internal.example.com", dom))
+	require.True(t, isDomainExcluded("host.lan.example.com", dom))
+	require.True(t, isDomainExcluded("vv.example.com", dom))
 	require.False(t, isDomainExcluded("api.example.com", dom))
+	require.False(t, isDomainExcluded("api.db-internal.example.com", dom))
+
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_EXCLUDED_SUB_DOMAINS", `lan, re:-internal\.`)
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, []string{"lan"}, doms[0].ExcludedSubDomains)
+	require.Len(t, doms[0].ExcludedSubDomainRegexes, 1)
+	require.Equal(t, `-internal\.`, doms[0].ExcludedSubDomainRegexes[0].String())
+
+	t.Setenv("DOMAIN1_EXCLUDED_SUB_DOMAINS", "re:(")
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.ErrorContains(t, err, `DOMAIN1_EXCLUDED_SUB_DOMAINS: invalid regex "("`)
+}
+
+func TestLabelMappingWarnsAboutInvalidExcludedSubDomains(t *testing.T) {
+	var buf bytes.Buffer
+	mapping := labelMapping(map[string]string{labelExcludedSubDomains: "lan, re:-internal\\., re:("}, "Container ID: abc", newBufferLogger(&buf))
+	require.Equal(t, []string{"lan"}, mapping.ExcludedSubDomains)
+	require.Len(t, mapping.ExcludedSubDomainRegexes, 1)
+	require.Contains(t, buf.String(), `Container ID: abc: ignoring invalid cloudflare.companion.excluded_subdomains entries: invalid regex "("`)
+}
+
+func TestPointDomainIgnoresLookalikeHosts(t *testing.T) {
+	var listed []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		listed = append(listed, r.URL.Query().Get("name"))
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","content":"lb.example.net"}]}`))
+	})
+	comp := &Companion{
+		cfg:    Config{Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}}},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{
+		"myexample.com":          {Source: 1},
+		"myexample.com.evil.net": {Source: 1},
+		"example.com.evil.net":   {Source: 1},
+		"app.example.com":        {Source: 1},
+		"example.com":            {Source: 1},
+	}, NewLogger("ERROR"))
+	require.ElementsMatch(t, []string{"app.example.com", "example.com"}, listed)
 }
 
 func TestParseBoolLikePython(t *testing.T) {
@@ -35,6 +196,91 @@ func TestParseBoolLikePython(t *testing.T) {
 	require.True(t, parseBoolLikePython("not-a-bool", true))
 }
 
+func TestParseTTL(t *testing.T) {
+	require.Equal(t, 1, parseTTL("auto", 300))
+	require.Equal(t, 1, parseTTL(" AUTO ", 300))
+	require.Equal(t, 120, parseTTL("120", 300))
+	require.Equal(t, 300, parseTTL("", 300))
+
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_TTL", "Auto")
+	doms, err := loadDomainConfigs(300, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, 1, doms[0].TTL)
+}
+
+func TestNextPollInterval(t *testing.T) {
+	minInterval, maxInterval := 5*time.Second, 60*time.Second
+	require.Equal(t, 10*time.Second, nextPollInterval(5*time.Second, false, minInterval, maxInterval))
+	require.Equal(t, 60*time.Second, nextPollInterval(40*time.Second, false, minInterval, maxInterval))
+	require.Equal(t, 5*time.Second, nextPollInterval(60*time.Second, true, minInterval, maxInterval))
+}
+
+func TestHostDelta(t *testing.T) {
+	previous := map[string]Mapping{"a.example.com": {Source: 2}, "b.example.com": {Source: 2}}
+	current := map[string]Mapping{"b.example.com": {Source: 2}, "d.example.com": {Source: 2}, "c.example.com": {Source: 2}}
+
+	added, removed := hostDelta(previous, current)
+	require.Equal(t, []string{"c.example.com", "d.example.com"}, added)
+	require.Equal(t, []string{"a.example.com"}, removed)
+
+	added, removed = hostDelta(current, current)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+
+	added, removed = hostDelta(nil, previous)
+	require.Equal(t, []string{"a.example.com", "b.example.com"}, added)
+	require.Empty(t, removed)
+}
+
+func TestPollTraefikFailureIsNotAnEmptyPoll(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	comp := &Companion{cfg: Config{TraefikPollURL: ts.URL, IncludedHosts: matchAll}}
+	mappings, ok := comp.pollTraefik(context.Background(), NewLogger("ERROR"))
+	require.False(t, ok)
+	require.Empty(t, mappings)
+}
+
+func TestTraefikPollCycleFailureKeepsPreviousHosts(t *testing.T) {
+	healthy := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"name":"a@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"}]`))
+	}))
+	defer ts.Close()
+
+	comp := &Companion{
+		cfg:    Config{TraefikPollURL: ts.URL, IncludedHosts: matchAll},
+		synced: map[string]int{},
+	}
+	logger := NewLogger("ERROR")
+	previous, changed := comp.traefikPollCycle(context.Background(), nil, logger)
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 2}}, previous)
+	require.False(t, changed)
+
+	healthy = false
+	current, changed := comp.traefikPollCycle(context.Background(), previous, logger)
+	require.Equal(t, previous, current)
+	require.False(t, changed)
+}
+
+func TestNextReconnectDelay(t *testing.T) {
+	minDelay, maxDelay := 2*time.Second, 60*time.Second
+	require.Equal(t, 4*time.Second, nextReconnectDelay(2*time.Second, minDelay, maxDelay))
+	require.Equal(t, 32*time.Second, nextReconnectDelay(16*time.Second, minDelay, maxDelay))
+	require.Equal(t, 60*time.Second, nextReconnectDelay(32*time.Second, minDelay, maxDelay))
+	require.Equal(t, 60*time.Second, nextReconnectDelay(60*time.Second, minDelay, maxDelay))
+	require.Equal(t, 2*time.Second, nextReconnectDelay(0, minDelay, maxDelay))
+}
+
 func TestGetSecretByEnvFromDefaultRunSecrets(t *testing.T) {
 	const secretName = "CF_TOKEN"
 	tempDir := t.TempDir()
@@ -70,3 +316,1977 @@ func TestGetSecretByEnvFromFileEnvWithName(t *testing.T) {
 	value := getSecretByEnv("CF_EMAIL")
 	require.Equal(t, "from-file-env", value)
 }
+
+func TestGetInitialMappingsInspectsContainersConcurrently(t *testing.T) {
+	docker := &fakeDocker{}
+	want := map[string]Mapping{}
+	for i := range 20 {
+		host := fmt.Sprintf("app%d.example.com", i)
+		labels := map[string]string{"traefik.http.routers.app.rule": "Host(`" + host + "`)"}
+		if i%2 == 0 {
+			labels["traefik.enable"] = "true"
+			want[host] = Mapping{Source: 1}
+		}
+		docker.containers = append(docker.containers, newContainer(fmt.Sprintf("c%d", i), labels))
+	}
+	comp := &Companion{
+		cfg: Config{
+			EnableDockerPoll:         true,
+			DockerInspectConcurrency: 4,
+			DockerListLabelFilter:    "traefik.enable",
+			TraefikVersion:           "2",
+			TraefikExposedByDefault:  true,
+			IncludedHosts:            matchAll,
+		},
+		docker: docker,
+	}
+
+	mappings, err := comp.GetInitialMappings(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+	require.Equal(t, want, mappings)
+}
+
+func TestAddToMappingsPriority(t *testing.T) {
+	mappings := map[string]Mapping{}
+	addToMappings(mappings, map[string]Mapping{"a.example.com": {Source: 2, Target: "traefik"}})
+	addToMappings(mappings, map[string]Mapping{"a.example.com": {Source: 1, Target: "docker"}})
+	require.Equal(t, "docker", mappings["a.example.com"].Target)
+
+	addToMappings(mappings, map[string]Mapping{"a.example.com": {Source: 1, Target: "second"}})
+	require.Equal(t, "docker", mappings["a.example.com"].Target)
+
+	addToMappings(mappings, map[string]Mapping{"a.example.com": {Source: 2, Priority: 10, Target: "preferred"}})
+	require.Equal(t, "preferred", mappings["a.example.com"].Target)
+
+	addToMappings(mappings, map[string]Mapping{"a.example.com": {Source: 1, Priority: -1, Target: "fallback"}})
+	require.Equal(t, "preferred", mappings["a.example.com"].Target)
+}
+
+func TestContainerPriorityLabel(t *testing.T) {
+	docker := &fakeDocker{containers: []container.InspectResponse{
+		newContainer("low", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)", labelExcludedSubDomains: "low"}),
+		newContainer("high", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)", labelExcludedSubDomains: "high", labelPriority: "5"}),
+		newContainer("bad", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)", labelExcludedSubDomains: "bad", labelPriority: "urgent"}),
+	}}
+	comp := &Companion{
+		cfg:    Config{EnableDockerPoll: true, TraefikVersion: "2", TraefikExposedByDefault: true, IncludedHosts: matchAll},
+		docker: docker,
+	}
+
+	mappings, err := comp.GetInitialMappings(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+	require.Equal(t, Mapping{Source: 1, Priority: 5, ExcludedSubDomains: []string{"high"}}, mappings["a.example.com"])
+}
+
+func TestSwarmZeroReplicaServiceIgnored(t *testing.T) {
+	var zero uint64
+	stopped := newSwarmService("svc-stopped", map[string]string{"traefik.http.routers.b.rule": "Host(`b.example.com`)"})
+	stopped.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &zero}
+	docker := &fakeDocker{
+		services: []swarm.Service{
+			newSwarmService("svc-running", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}),
+			stopped,
+		},
+	}
+	comp := &Companion{
+		cfg:    Config{DockerSwarmMode: true, DockerSwarmIgnoreStopped: true, TraefikVersion: "2", TraefikExposedByDefault: true, IncludedHosts: matchAll},
+		docker: docker,
+		synced: map[string]int{},
+	}
+	logger := NewLogger("ERROR")
+
+	mappings, err := comp.GetInitialMappings(context.Background(), logger)
+	require.NoError(t, err)
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, mappings)
+
+	event := events.Message{Type: events.ServiceEventType, Action: "update", Actor: events.Actor{ID: "svc-stopped"}}
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, logger))
+
+	comp.cfg.DockerSwarmIgnoreStopped = false
+	require.Equal(t, map[string]Mapping{"b.example.com": {Source: 1}}, comp.processDockerEvent(context.Background(), event, logger))
+}
+
+func TestCheckTraefikWarnsOnUnparsableHostRule(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name":"ok@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"},
+			{"name":"odd@file","rule":"Host(\"b.example.com\")","status":"enabled"}
+		]`))
+	}))
+	defer ts.Close()
+
+	comp := &Companion{cfg: Config{TraefikPollURL: ts.URL, IncludedHosts: matchAll}}
+	buf := &bytes.Buffer{}
+
+	mappings, ok := comp.checkTraefik(context.Background(), newBufferLogger(buf))
+	require.True(t, ok)
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 2}}, mappings)
+	require.Contains(t, buf.String(), `Traefik Router Name: odd@file has a Host rule without parsable hostnames: Host("b.example.com")`)
+	require.NotContains(t, buf.String(), "ok@docker has a Host rule")
+}
+
+func TestWaitForTraefik(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if strings.HasPrefix(r.URL.Path, "/down") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer ts.Close()
+	comp := &Companion{cfg: Config{TraefikPollURL: ts.URL, TraefikStartupTimeoutSecs: 5}}
+	buf := &bytes.Buffer{}
+
+	require.True(t, comp.waitForTraefik(context.Background(), time.Millisecond, newBufferLogger(buf)))
+	require.Equal(t, 3, requests)
+	require.Contains(t, buf.String(), "Traefik API not ready, retrying in 1ms: status 503")
+
+	// Without time left it gives up after the first attempt.
+	requests = 0
+	comp.cfg.TraefikPollURL = ts.URL + "/down"
+	comp.cfg.TraefikStartupTimeoutSecs = 0
+	require.False(t, comp.waitForTraefik(context.Background(), time.Millisecond, newBufferLogger(buf)))
+	require.Equal(t, 1, requests)
+	require.Contains(t, buf.String(), "Traefik API not ready after 0s, continuing without waiting: status 502")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	comp.cfg.TraefikStartupTimeoutSecs = 5
+	require.False(t, comp.waitForTraefik(ctx, time.Millisecond, NewLogger("ERROR")))
+}
+
+func TestCheckTraefikCollapsesSharedHosts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name":"app-canary@docker","rule":"Host(` + "`app.example.com`" + `) && Headers(` + "`X-Canary`, `true`" + `)","status":"enabled"},
+			{"name":"app@docker","rule":"Host(` + "`app.example.com`" + `)","status":"enabled"}
+		]`))
+	}))
+	defer ts.Close()
+	var posts int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			TraefikPollURL: ts.URL,
+			IncludedHosts:  matchAll,
+			Domains:        []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+	buf := &bytes.Buffer{}
+
+	mappings, ok := comp.checkTraefik(context.Background(), newBufferLogger(buf))
+	require.True(t, ok)
+	require.Equal(t, map[string]Mapping{"app.example.com": {Source: 2}}, mappings)
+	require.Contains(t, buf.String(), "Traefik Router Name: app@docker shares Hostname app.example.com with an earlier router, keeping that one")
+	require.Equal(t, SyncResult{Created: 1}, comp.SyncMappings(context.Background(), mappings, NewLogger("ERROR")))
+	require.Equal(t, 1, posts)
+}
+
+func TestCheckTraefikIncludeStatuses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name":"a@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"},
+			{"name":"b@docker","rule":"Host(` + "`b.example.com`" + `)","status":"warning"},
+			{"name":"c@docker","rule":"Host(` + "`c.example.com`" + `)","status":"disabled"}
+		]`))
+	}))
+	defer ts.Close()
+
+	comp := &Companion{cfg: Config{TraefikPollURL: ts.URL, IncludedHosts: matchAll}}
+	mappings, ok := comp.checkTraefik(context.Background(), NewLogger("ERROR"))
+	require.True(t, ok)
+	require.Equal(t, []string{"a.example.com"}, slices.Sorted(maps.Keys(mappings)))
+
+	comp.cfg.TraefikIncludeStatuses = []string{"enabled", "warning"}
+	mappings, ok = comp.checkTraefik(context.Background(), NewLogger("ERROR"))
+	require.True(t, ok)
+	require.Equal(t, []string{"a.example.com", "b.example.com"}, slices.Sorted(maps.Keys(mappings)))
+}
+
+func TestRefreshEntriesSkipsUnchangedRecords(t *testing.T) {
+	var updates []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			updates = append(updates, r.URL.Path)
+			_, _ = w.Write([]byte(`{"success":true,"result":{}}`))
+			return
+		}
+		switch r.URL.Query().Get("name") {
+		case "same.example.com":
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"same","type":"CNAME","content":"lb.example.net","ttl":300,"proxied":false}]}`))
+		case "proxied.example.com":
+			// Cloudflare reports proxied records with the automatic TTL.
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"proxied","type":"CNAME","content":"lb.example.net","ttl":1,"proxied":true}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"ttl","type":"CNAME","content":"lb.example.net","ttl":1,"proxied":false}]}`))
+		}
+	})
+	comp := &Companion{
+		cfg: Config{
+			RefreshEntries: true,
+			Domains:        []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 300}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+
+	proxied := true
+	res := comp.SyncMappings(context.Background(), map[string]Mapping{
+		"same.example.com":    {Source: 1},
+		"proxied.example.com": {Source: 1, Proxied: &proxied},
+		"ttl.example.com":     {Source: 1},
+	}, NewLogger("ERROR"))
+	require.Equal(t, SyncResult{Updated: 1, Skipped: 2}, res)
+	require.Equal(t, []string{"/zones/zone/dns_records/ttl"}, updates)
+
+	comp.cfg.RefreshEntries = false
+	comp.synced = map[string]int{}
+	updates = nil
+	comp.SyncMappings(context.Background(), map[string]Mapping{"ttl.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Empty(t, updates)
+}
+
+func TestPreserveExistingProxied(t *testing.T) {
+	var updates []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			updates = append(updates, req)
+			_, _ = w.Write([]byte(`{"success":true,"result":{}}`))
+			return
+		}
+		switch r.URL.Query().Get("name") {
+		case "moved.example.com":
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"moved","type":"CNAME","content":"old.example.net","ttl":1,"proxied":false}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"same","type":"CNAME","content":"lb.example.net","ttl":1,"proxied":false}]}`))
+		}
+	})
+	comp := &Companion{cfg: Config{
+		RefreshEntries:          true,
+		PreserveExistingProxied: true,
+		Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 1, Proxied: true}},
+	}, cf: cf}
+
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "same.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.True(t, comp.pointDomain(context.Background(), "moved.example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.Len(t, updates, 1)
+	require.Equal(t, "lb.example.net", updates[0].Content)
+	require.False(t, updates[0].Proxied)
+
+	// A per-host override still wins over the existing record.
+	proxied := true
+	require.True(t, comp.pointDomain(context.Background(), "same.example.com", Mapping{Source: 2, Proxied: &proxied}, &SyncResult{}, NewLogger("ERROR")))
+	require.Len(t, updates, 2)
+	require.True(t, updates[1].Proxied)
+}
+
+func TestZoneLabelPinsDomain(t *testing.T) {
+	mapping := labelMapping(map[string]string{labelZone: " zone2 "}, "Container ID: abc", NewLogger("ERROR"))
+	require.Equal(t, "zone2", mapping.ZoneID)
+
+	var posts []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts = append(posts, r.URL.Path)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{cfg: Config{
+		DomainMatchMode: domainMatchLongestSuffix,
+		Domains: []DomainConfig{
+			{Name: "example.com", RecordType: "CNAME", ZoneID: "zone1", TargetDomain: "lb.example.net"},
+			{Name: "dev.example.com", RecordType: "CNAME", ZoneID: "zone2", TargetDomain: "lb.example.net"},
+		},
+	}, cf: cf}
+
+	// Without the label the longest suffix wins; the label pins the host to
+	// the parent domain's zone instead.
+	require.True(t, comp.pointDomain(context.Background(), "a.dev.example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.True(t, comp.pointDomain(context.Background(), "a.dev.example.com", Mapping{Source: 1, ZoneID: "zone1"}, &SyncResult{}, NewLogger("ERROR")))
+	require.Equal(t, []string{"/zones/zone2/dns_records", "/zones/zone1/dns_records"}, posts)
+	domains, _ := comp.mappingDomains("a.dev.example.com", Mapping{Source: 1, ZoneID: "zone1"})
+	require.Equal(t, []string{"example.com"}, domains)
+
+	buf := &bytes.Buffer{}
+	res := &SyncResult{}
+	require.False(t, comp.pointDomain(context.Background(), "a.dev.example.com", Mapping{Source: 1, ZoneID: "zone3"}, res, newBufferLogger(buf)))
+	require.Equal(t, 1, res.Failed)
+	require.Contains(t, buf.String(), "a.dev.example.com: cloudflare.companion.zone label pins zone zone3, which no configured domain uses")
+	require.Len(t, posts, 2)
+}
+
+func TestMaxChangesPerRun(t *testing.T) {
+	var posts int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			MaxChangesPerRun: 2,
+			Domains:          []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+	buf := &bytes.Buffer{}
+	mappings := map[string]Mapping{}
+	for _, host := range []string{"a", "b", "c", "d", "e"} {
+		mappings[host+".example.com"] = Mapping{Source: 1}
+	}
+
+	res := comp.SyncMappings(context.Background(), mappings, newBufferLogger(buf))
+	require.Equal(t, 2, posts)
+	require.Equal(t, SyncResult{Created: 2}, res)
+	require.Contains(t, buf.String(), "Reached MAX_CHANGES_PER_RUN=2, aborting the remaining 3 hosts of this sync")
+	require.Len(t, comp.synced, 2)
+
+	// The cap also stops the writes of a single host part way.
+	posts = 0
+	comp.cfg.Domains[0].RecordType = "A"
+	comp.cfg.Domains[0].TargetDomain = "192.0.2.1,192.0.2.2,192.0.2.3"
+	res = SyncResult{}
+	require.False(t, comp.pointDomain(context.Background(), "f.example.com", Mapping{Source: 1}, &res, NewLogger("ERROR")))
+	require.Equal(t, 2, posts)
+	require.Equal(t, SyncResult{Created: 2, Failed: 1, FailedHosts: []string{"f.example.com"}}, res)
+}
+
+func TestProxiedDrift(t *testing.T) {
+	var updates []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			updates = append(updates, req)
+			_, _ = w.Write([]byte(`{"success":true,"result":{}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net","ttl":1,"proxied":false}]}`))
+	})
+	comp := &Companion{cfg: Config{
+		Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 1, Proxied: true}},
+	}, cf: cf}
+	buf := &bytes.Buffer{}
+
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, res, newBufferLogger(buf)))
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.Empty(t, updates)
+	require.Contains(t, buf.String(), "a.example.com record rec is proxied=false in Cloudflare but configured proxied=true, leaving it; set FIX_PROXIED_DRIFT=true to update it")
+
+	comp.cfg.FixProxiedDrift = true
+	res = &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Updated: 1}, *res)
+	require.Len(t, updates, 1)
+	require.True(t, updates[0].Proxied)
+}
+
+func TestSyncMappingsVerifiesAndRecreatesDeletedRecord(t *testing.T) {
+	var created []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			created = append(created, r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			VerifySampleRate: 0.5,
+			Domains:          []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{"a.example.com": 2},
+		sample: func() float64 { return 0.9 },
+	}
+	logger := NewLogger("ERROR")
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 2}}, logger)
+	require.Empty(t, created)
+
+	comp.sample = func() float64 { return 0.1 }
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 2}}, logger)
+	require.Equal(t, []string{"/zones/zone/dns_records"}, created)
+}
+
+func TestContainerMappingsNetworkFilter(t *testing.T) {
+	labels := map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}
+	comp := &Companion{cfg: Config{TraefikVersion: "2", TraefikExposedByDefault: true, IncludedHosts: matchAll, DockerNetworkFilter: "proxy"}}
+	logger := NewLogger("ERROR")
+
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.containerMappings(newContainer("c1", labels, "bridge", "proxy"), logger))
+	require.Empty(t, comp.containerMappings(newContainer("c2", labels, "bridge"), logger))
+
+	comp.cfg.DockerNetworkFilter = ""
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.containerMappings(newContainer("c2", labels, "bridge"), logger))
+}
+
+func TestCheckDNSSECWarnsForProxiedDomains(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zones/signed/dnssec":
+			_, _ = w.Write([]byte(`{"success":true,"result":{"status":"active"}}`))
+		case "/zones/plain/dnssec":
+			_, _ = w.Write([]byte(`{"success":true,"result":{"status":"disabled"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	comp := &Companion{
+		cfg: Config{Domains: []DomainConfig{
+			{Name: "signed.com", ZoneID: "signed", Proxied: true},
+			{Name: "plain.com", ZoneID: "plain", Proxied: true},
+		}},
+		cf: cf,
+	}
+	buf := &bytes.Buffer{}
+
+	comp.CheckDNSSEC(newBufferLogger(buf))
+	require.Equal(t, map[string]bool{"signed": true, "plain": false}, comp.dnssec)
+	require.Contains(t, buf.String(), "Domain signed.com has DNSSEC enabled")
+	require.NotContains(t, buf.String(), "Domain plain.com has DNSSEC enabled")
+}
+
+func TestExposedByDefaultRequiresEnableLabel(t *testing.T) {
+	rule := map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}
+	enabled := map[string]string{"traefik.enable": "true", "traefik.http.routers.a.rule": "Host(`a.example.com`)"}
+	comp := &Companion{cfg: Config{TraefikExposedByDefault: true, IncludedHosts: matchAll}}
+	logger := NewLogger("ERROR")
+
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.checkContainerT2("c1", rule, logger))
+	disabled := map[string]string{"traefik.enable": "false", "traefik.http.routers.a.rule": "Host(`a.example.com`)"}
+	require.Empty(t, comp.checkContainerT2("c1", disabled, logger))
+	require.Empty(t, comp.checkServiceT2("s1", disabled, nil, logger))
+
+	comp.cfg.TraefikExposedByDefault = false
+	require.Empty(t, comp.checkContainerT2("c1", rule, logger))
+	require.Empty(t, comp.checkServiceT2("s1", rule, nil, logger))
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.checkContainerT2("c1", enabled, logger))
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.checkServiceT2("s1", enabled, nil, logger))
+}
+
+func TestRuntimeTogglesSuppressActivity(t *testing.T) {
+	cfCalls := 0
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, _ *http.Request) {
+		cfCalls++
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r1","content":"lb.example.net"}]}`))
+	})
+	traefikCalls := 0
+	traefik := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		traefikCalls++
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer traefik.Close()
+
+	comp := &Companion{
+		cfg: Config{
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			TraefikPollURL:          traefik.URL,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		docker: &fakeDocker{containers: []container.InspectResponse{newContainer("c1", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"})}},
+		synced: map[string]int{},
+	}
+	logger := NewLogger("ERROR")
+	event := events.Message{Type: events.ContainerEventType, Action: "start", Actor: events.Actor{ID: "c1"}}
+
+	comp.toggles.SetPaused(toggleDocker, true)
+	comp.handleDockerEvent(context.Background(), event, logger)
+	require.Zero(t, cfCalls)
+
+	comp.toggles.SetPaused(toggleTraefik, true)
+	_, ok := comp.pollTraefik(context.Background(), logger)
+	require.False(t, ok)
+	require.Zero(t, traefikCalls)
+
+	comp.toggles.SetPaused(toggleCloudflare, true)
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, logger)
+	require.Zero(t, cfCalls)
+	require.Empty(t, comp.synced)
+
+	comp.toggles.SetPaused(toggleDocker, false)
+	comp.toggles.SetPaused(toggleTraefik, false)
+	comp.toggles.SetPaused(toggleCloudflare, false)
+	_, ok = comp.pollTraefik(context.Background(), logger)
+	require.True(t, ok)
+	require.Equal(t, 1, traefikCalls)
+	comp.handleDockerEvent(context.Background(), event, logger)
+	require.Equal(t, 1, cfCalls)
+	require.Equal(t, map[string]int{"a.example.com": 1}, comp.synced)
+}
+
+func TestMaxHostsPerSource(t *testing.T) {
+	labels := map[string]string{"traefik.http.routers.a.rule": "Host(`c.example.com`) || Host(`a.example.com`) || Host(`b.example.com`)"}
+	comp := &Companion{cfg: Config{TraefikExposedByDefault: true, IncludedHosts: matchAll, MaxHostsPerSource: 2}}
+	buf := &bytes.Buffer{}
+
+	mappings := comp.checkContainerT2("c1", labels, newBufferLogger(buf))
+	require.ElementsMatch(t, []string{"a.example.com", "b.example.com"}, slices.Collect(maps.Keys(mappings)))
+	require.Contains(t, buf.String(), "Container ID: c1 contributes 3 hosts, truncating to MAX_HOSTS_PER_SOURCE=2")
+
+	require.Equal(t, []string{"x"}, comp.limitHostList("router", []string{"x"}, NewLogger("ERROR")))
+	comp.cfg.MaxHostsPerSource = 0
+	require.Len(t, comp.checkContainerT2("c1", labels, NewLogger("ERROR")), 3)
+}
+
+func TestValidateTargets(t *testing.T) {
+	cfg := Config{
+		TargetDomain: "unused.example.net",
+		Domains: []DomainConfig{
+			{Name: "example.com", RecordType: "CNAME", TargetDomain: "lb.example.net"},
+			{Name: "example.org", RecordType: "CNAME", TargetDomain: "typo.example.net"},
+			{Name: "example.net", RecordType: "CNAME", TargetDomain: "192.0.2.1"},
+			{Name: "example.info", RecordType: "MX", TargetDomain: "mail.example.net"},
+			{Name: "example.dev", RecordType: "TXT", TargetDomain: "v=spf1 -all"},
+		},
+	}
+	var looked []string
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		_, hasDeadline := ctx.Deadline()
+		require.True(t, hasDeadline)
+		looked = append(looked, host)
+		if host == "typo.example.net" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+
+	err := validateTargets(context.Background(), cfg, time.Second, lookup)
+	require.EqualError(t, err, "typo.example.net does not resolve: no such host")
+	require.Equal(t, []string{"lb.example.net", "typo.example.net"}, looked)
+
+	cfg.Domains = slices.Delete(cfg.Domains, 1, 2)
+	require.NoError(t, validateTargets(context.Background(), cfg, time.Second, lookup))
+}
+
+func TestLoadTraefikHostFiltersRequireExplicitIncludes(t *testing.T) {
+	includes, _, err := loadTraefikHostFilters("", false, false)
+	require.NoError(t, err)
+	require.Len(t, includes, 1)
+	require.Equal(t, ".*", includes[0].String())
+
+	_, _, err = loadTraefikHostFilters("", true, false)
+	require.EqualError(t, err, "TRAEFIK_REQUIRE_EXPLICIT_INCLUDES is set but no TRAEFIK_INCLUDED_HOSTn is defined")
+
+	t.Setenv("TRAEFIK_INCLUDED_HOST1", `^a\.example\.com$`)
+	includes, _, err = loadTraefikHostFilters("", true, false)
+	require.NoError(t, err)
+	require.Len(t, includes, 1)
+}
+
+func TestLoadDomainConfigsRecordTypeOverride(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_RC_TYPE", "a")
+	t.Setenv("DOMAIN2_TARGET_DOMAIN", "192.0.2.10")
+
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Len(t, doms, 2)
+	require.Equal(t, "CNAME", doms[0].RecordType)
+	require.Equal(t, "A", doms[1].RecordType)
+	require.Equal(t, "192.0.2.10", doms[1].TargetDomain)
+
+	t.Setenv("DOMAIN2_TARGET_DOMAIN", "lb.example.net")
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, `DOMAIN2: A record content "lb.example.net" is not an IPv4 address`)
+}
+
+func TestLoadDomainConfigsTargetIP(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_RC_TYPE", "A")
+	t.Setenv("DOMAIN2_TARGET_IP", "192.0.2.10")
+
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, "lb.example.net", doms[0].TargetDomain)
+	require.Equal(t, "192.0.2.10", doms[1].TargetDomain)
+
+	t.Setenv("DOMAIN2_TARGET_IP", "2001:db8::1")
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, `DOMAIN2_TARGET_IP: A record content "2001:db8::1" is not an IPv4 address`)
+
+	t.Setenv("DOMAIN2_RC_TYPE", "AAAA")
+	doms, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1", doms[1].TargetDomain)
+
+	t.Setenv("DOMAIN2_RC_TYPE", "")
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, "DOMAIN2_TARGET_IP needs A or AAAA records, got CNAME")
+}
+
+func TestRecordTypeValidation(t *testing.T) {
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("RC_TYPE", " cname ")
+
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "CNAME", cfg.RecordType)
+	require.Equal(t, "CNAME", cfg.Domains[0].RecordType)
+
+	t.Setenv("RC_TYPE", "CAA")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, `RC_TYPE must be one of CNAME, A, AAAA, TXT, MX, SRV, got "CAA"`)
+
+	t.Setenv("RC_TYPE", "")
+	t.Setenv("DOMAIN1_RC_TYPE", "ns")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, `DOMAIN1_RC_TYPE must be one of CNAME, A, AAAA, TXT, MX, SRV, got "NS"`)
+
+	t.Setenv("DOMAIN1_RC_TYPE", "txt")
+	t.Setenv("TARGET_DOMAIN", "")
+	cfg, err = LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "TXT", cfg.Domains[0].RecordType)
+
+	t.Setenv("DOMAIN1_RC_TYPE", "mx")
+	t.Setenv("TARGET_DOMAIN", "mail.example.net")
+	t.Setenv("DOMAIN1_MX_PRIORITY", "70000")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, "DOMAIN1_MX_PRIORITY must be between 0 and 65535")
+}
+
+func TestValidateRecordContent(t *testing.T) {
+	require.NoError(t, validateRecordContent("A", "192.0.2.1", false))
+	require.Error(t, validateRecordContent("A", "2001:db8::1", false))
+	require.NoError(t, validateRecordContent("AAAA", "2001:db8::1", false))
+	require.Error(t, validateRecordContent("AAAA", "192.0.2.1", false))
+	require.NoError(t, validateRecordContent("CNAME", "lb.example.net", false))
+	require.NoError(t, validateRecordContent("CNAME", "192.0.2.1", false))
+	require.NoError(t, validateRecordContent("MX", "mail.example.net", false))
+	require.Error(t, validateRecordContent("MX", "192.0.2.1", false))
+	require.NoError(t, validateRecordContent("TXT", "v=spf1 -all", false))
+	require.Error(t, validateRecordContent("TXT", "", false))
+	require.Error(t, validateRecordContent("TXT", strings.Repeat("a", maxTXTLength+1), false))
+}
+
+func TestValidateRecordContentStrict(t *testing.T) {
+	require.NoError(t, validateRecordContent("CNAME", "lb.example.net", true))
+	require.NoError(t, validateRecordContent("CNAME", "lb.example.net.", true))
+	require.EqualError(t, validateRecordContent("CNAME", "192.0.2.1", true), `CNAME record content "192.0.2.1" is an IP address, not a hostname`)
+	require.EqualError(t, validateRecordContent("CNAME", "2001:db8::1", true), `CNAME record content "2001:db8::1" is an IP address, not a hostname`)
+	require.EqualError(t, validateRecordContent("CNAME", "-bad.example.net", true), `CNAME record content "-bad.example.net" is not a valid hostname`)
+	require.EqualError(t, validateRecordContent("CNAME", "lb..example.net", true), `CNAME record content "lb..example.net" is not a valid hostname`)
+	require.Error(t, validateRecordContent("A", "lb.example.net", true))
+	require.Error(t, validateRecordContent("AAAA", "192.0.2.1", true))
+}
+
+func TestStrictTargetValidationBlocksWrite(t *testing.T) {
+	var methods []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			StrictTargetValidation: true,
+			Domains:                []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "192.0.2.1"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Empty(t, methods)
+	require.Empty(t, comp.synced)
+}
+
+func TestContainerLabelExcludedSubDomains(t *testing.T) {
+	var listed []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		listed = append(listed, r.URL.Query().Get("name"))
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r1","content":"lb.example.net"}]}`))
+	})
+	labels := map[string]string{
+		"traefik.http.routers.a.rule": "Host(`api.internal.example.com`) || Host(`api.example.com`)",
+		labelExcludedSubDomains:       "internal, staging",
+	}
+	comp := &Companion{
+		cfg: Config{
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", ExcludedSubDomains: []string{"dev"}}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+	logger := NewLogger("ERROR")
+
+	mappings := comp.checkContainerT2("c1", labels, logger)
+	require.Equal(t, []string{"internal", "staging"}, mappings["api.example.com"].ExcludedSubDomains)
+
+	comp.SyncMappings(context.Background(), mappings, logger)
+	require.Equal(t, []string{"api.example.com"}, listed)
+	require.Equal(t, []string{"dev"}, comp.cfg.Domains[0].ExcludedSubDomains)
+}
+
+func TestLoadTraefikHostFiltersStrictIncludes(t *testing.T) {
+	includes, _, err := loadTraefikHostFilters("", false, true)
+	require.NoError(t, err)
+	require.Empty(t, includes)
+	require.False(t, isMatching("a.example.com", includes))
+
+	t.Setenv("TRAEFIK_INCLUDED_HOST1", `example\.com$`)
+	includes, _, err = loadTraefikHostFilters("", false, true)
+	require.NoError(t, err)
+	require.True(t, isMatching("a.example.com", includes))
+}
+
+func TestLoadDomainConfigsRequiresTarget(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_TARGET_DOMAIN", "lb.example.org")
+
+	_, err := loadDomainConfigs(1, "", "CNAME")
+	require.EqualError(t, err, "DOMAIN1 has no content for CNAME records: set DOMAIN1_TARGET_DOMAIN or TARGET_DOMAIN")
+
+	t.Setenv("DOMAIN1_TARGET_DOMAIN", "lb.example.com")
+	doms, err := loadDomainConfigs(1, "", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, "lb.example.com", doms[0].TargetDomain)
+	require.Equal(t, "lb.example.org", doms[1].TargetDomain)
+}
+
+func TestSyncMappingsTagsLinesWithCycleID(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r1","content":"lb.example.net"}]}`))
+	})
+	ids := []string{"c0ffee", "beef01"}
+	comp := &Companion{
+		cfg: Config{
+			Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+		cycleID: func() string {
+			id := ids[0]
+			ids = ids[1:]
+			return id
+		},
+	}
+	cycleRx := regexp.MustCompile(`\[cycle=([0-9a-f]{6})\] `)
+	cycleIDs := func(out string) map[string]int {
+		ids := map[string]int{}
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			match := cycleRx.FindStringSubmatch(line)
+			require.NotNil(t, match, line)
+			ids[match[1]]++
+		}
+		return ids
+	}
+
+	first := &bytes.Buffer{}
+	cf.logger = newBufferLogger(first)
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}, "b.example.com": {Source: 1}}, newBufferLogger(first))
+	require.Contains(t, first.String(), "[cycle=c0ffee] Querying Cloudflare API: GET")
+	require.Equal(t, []string{"c0ffee"}, slices.Collect(maps.Keys(cycleIDs(first.String()))))
+
+	second := &bytes.Buffer{}
+	cf.logger = newBufferLogger(second)
+	comp.synced = map[string]int{}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, newBufferLogger(second))
+	require.Contains(t, second.String(), "[cycle=beef01] Cloudflare API response: GET")
+	require.Equal(t, []string{"beef01"}, slices.Collect(maps.Keys(cycleIDs(second.String()))))
+}
+
+func TestPointDomainTagsLinesWithHostAndZone(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r1","content":"lb.example.net"}]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf: cf,
+	}
+	buf := &bytes.Buffer{}
+	comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 2}, &SyncResult{}, newBufferLogger(buf))
+
+	require.Contains(t, buf.String(), "Existing record: a.example.com already points to lb.example.net host=a.example.com source=traefik zone=zone\n")
+}
+
+func TestHostFiltersApplyToLabelDiscovery(t *testing.T) {
+	labels := map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`) || Host(`internal.example.com`)"}
+	comp := &Companion{cfg: Config{
+		TraefikVersion:          "2",
+		TraefikExposedByDefault: true,
+		IncludedHosts:           matchAll,
+		ExcludedHosts:           []*regexp.Regexp{regexp.MustCompile(`^internal\.`)},
+	}}
+	buf := &bytes.Buffer{}
+	logger := newBufferLogger(buf)
+
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.checkContainerT2("c1", labels, logger))
+	require.Contains(t, buf.String(), "Ignoring Container ID: c1 Hostname internal.example.com because of host filters")
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.checkServiceT2("s1", labels, nil, logger))
+	require.Contains(t, buf.String(), "Ignoring Service ID: s1 Hostname internal.example.com because of host filters")
+	require.Empty(t, comp.checkContainerT1("c1", map[string]string{"traefik.frontend.rule": "Host:internal.example.com"}, logger))
+
+	comp.cfg.IncludedHosts = []*regexp.Regexp{regexp.MustCompile(`^internal\.`)}
+	comp.cfg.ExcludedHosts = nil
+	require.Equal(t, map[string]Mapping{"internal.example.com": {Source: 1}}, comp.checkContainerT2("c1", labels, logger))
+}
+
+func TestLoadDomainConfigsProfiles(t *testing.T) {
+	t.Setenv("PROFILE_public_TTL", "300")
+	t.Setenv("PROFILE_public_PROXIED", "true")
+	t.Setenv("PROFILE_public_COMMENT", "managed")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_PROFILE", "public")
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_PROFILE", "public")
+	t.Setenv("DOMAIN2_TTL", "60")
+	t.Setenv("DOMAIN2_PROXIED", "false")
+
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Len(t, doms, 2)
+	require.Equal(t, 300, doms[0].TTL)
+	require.True(t, doms[0].Proxied)
+	require.Equal(t, "managed", doms[0].Comment)
+	require.Equal(t, 60, doms[1].TTL)
+	require.False(t, doms[1].Proxied)
+	require.Equal(t, "managed", doms[1].Comment)
+
+	t.Setenv("DOMAIN2_PROFILE", "internal")
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, `DOMAIN2 references undefined profile "internal"`)
+}
+
+func TestPerDomainCloudflareToken(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("second-account\n"), 0o600))
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_CF_TOKEN_FILE", filepath.Join(dir, "token"))
+
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Equal(t, "", doms[0].CloudflareToken)
+	require.Equal(t, "second-account", doms[1].CloudflareToken)
+
+	var mu sync.Mutex
+	zones := map[string][]string{}
+	handler := func(account string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				mu.Lock()
+				zones[account] = append(zones[account], strings.Split(r.URL.Path, "/")[2])
+				mu.Unlock()
+				_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+		}
+	}
+	comp := &Companion{
+		cfg:    Config{Domains: doms},
+		cf:     newTestCloudflare(t, handler("global")),
+		zoneCF: map[string]*CloudflareAPI{"second-account": newTestCloudflare(t, handler("second"))},
+		synced: map[string]int{},
+	}
+	comp.SyncMappings(context.Background(), map[string]Mapping{
+		"a.example.com": {Source: 1},
+		"a.example.org": {Source: 1},
+	}, NewLogger("ERROR"))
+	require.Equal(t, map[string][]string{"global": {"zone1"}, "second": {"zone2"}}, zones)
+}
+
+func TestApexRecordType(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_APEX_RC_TYPE", "a")
+	_, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.EqualError(t, err, `DOMAIN1 apex: A record content "lb.example.net" is not an IPv4 address`)
+
+	t.Setenv("DOMAIN1_APEX_TARGET_DOMAIN", "192.0.2.10")
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var created []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			mu.Lock()
+			created = append(created, req)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: doms}, cf: cf}
+	comp.pointDomain(context.Background(), "example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR"))
+	comp.pointDomain(context.Background(), "www.example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR"))
+
+	require.Len(t, created, 2)
+	require.Equal(t, []string{"A", "example.com", "192.0.2.10"}, []string{created[0].Type, created[0].Name, created[0].Content})
+	require.Equal(t, []string{"CNAME", "www.example.com", "lb.example.net"}, []string{created[1].Type, created[1].Name, created[1].Content})
+
+	buf := &bytes.Buffer{}
+	comp.cfg.Domains[0].ApexRecordType = ""
+	comp.pointDomain(context.Background(), "example.com", Mapping{Source: 1}, &SyncResult{}, newBufferLogger(buf))
+	require.Contains(t, buf.String(), "Creating unproxied CNAME at the apex of example.com")
+}
+
+func TestTXTAndMXRecords(t *testing.T) {
+	mapping := labelMapping(map[string]string{labelContent: " v=spf1 -all ", labelMXPriority: "20"}, "Container ID: abc", NewLogger("ERROR"))
+	require.Equal(t, "v=spf1 -all", mapping.Content)
+	require.Equal(t, 20, *mapping.MXPriority)
+
+	var mu sync.Mutex
+	var created []DNSRecordRequest
+	var updated []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			mu.Lock()
+			created = append(created, req)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+		case http.MethodPut:
+			mu.Lock()
+			updated = append(updated, r.URL.Path)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"result":[` +
+				`{"id":"spf","type":"TXT","content":"\"v=spf1 -all\"","ttl":1},` +
+				`{"id":"verify","type":"TXT","content":"google-site-verification=abc","ttl":1}]}`))
+		}
+	})
+	comp := &Companion{cfg: Config{Domains: []DomainConfig{
+		{Name: "example.com", RecordType: "TXT", ZoneID: "zone", TTL: 1},
+		{Name: "example.org", RecordType: "MX", ZoneID: "zone", TTL: 1, TargetDomain: "mail.example.net", MXPriority: 10},
+	}}, cf: cf}
+
+	// Without content there is nothing to write.
+	res := &SyncResult{}
+	require.False(t, comp.pointDomain(context.Background(), "example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, 1, res.Failed)
+
+	// The existing SPF record matches despite the quotes; the other TXT
+	// record is not touched.
+	res = &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "example.com", Mapping{Source: 1, Content: "v=spf1 -all"}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+
+	res = &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "example.com", Mapping{Source: 1, Content: "hello"}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Created: 1}, *res)
+
+	priority := 5
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1, MXPriority: &priority}, &SyncResult{}, NewLogger("ERROR")))
+
+	require.Empty(t, updated)
+	require.Len(t, created, 3)
+	require.Equal(t, "hello", created[0].Content)
+	require.False(t, created[0].Proxied)
+	require.Nil(t, created[0].Priority)
+	require.Equal(t, "MX", created[1].Type)
+	require.Equal(t, 10, *created[1].Priority)
+	require.Equal(t, 5, *created[2].Priority)
+}
+
+func TestMXPriorityChangeUpdatesRecord(t *testing.T) {
+	var updated []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			updated = append(updated, req)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"mx"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"mx","type":"MX","content":"mail.example.net","ttl":1,"priority":10}]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: []DomainConfig{
+		{Name: "example.org", RecordType: "MX", ZoneID: "zone", TTL: 1, TargetDomain: "mail.example.net", MXPriority: 10},
+	}}, cf: cf}
+
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.Empty(t, updated)
+
+	priority := 20
+	res = &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1, MXPriority: &priority}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Updated: 1}, *res)
+	require.Len(t, updated, 1)
+	require.Equal(t, 20, *updated[0].Priority)
+
+	comp.cfg.Domains[0].MXPriority = 5
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.Len(t, updated, 2)
+	require.Equal(t, 5, *updated[1].Priority)
+}
+
+func TestProxiedIgnoredForUnproxyableTypes(t *testing.T) {
+	var created DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: []DomainConfig{
+		{Name: "example.org", RecordType: "MX", ZoneID: "zone", TargetDomain: "mail.example.net", Proxied: true},
+	}}, cf: cf}
+	buf := &bytes.Buffer{}
+	require.True(t, comp.pointDomain(context.Background(), "example.org", Mapping{Source: 1}, &SyncResult{}, newBufferLogger(buf)))
+	require.Equal(t, "MX", created.Type)
+	require.False(t, created.Proxied)
+	require.Contains(t, buf.String(), "example.org: Cloudflare cannot proxy MX records, writing it unproxied")
+}
+
+func TestMultipleTargets(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	_, err := loadDomainConfigs(1, "a.example.net, b.example.net", "CNAME")
+	require.EqualError(t, err, "DOMAIN1: CNAME records take a single target, got 2")
+	_, err = loadDomainConfigs(1, "192.0.2.1,lb.example.net", "A")
+	require.EqualError(t, err, `DOMAIN1: A record content "lb.example.net" is not an IPv4 address`)
+	doms, err := loadDomainConfigs(1, "192.0.2.1, 192.0.2.2, 192.0.2.3", "A")
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, splitTargets(doms[0].RecordType, doms[0].TargetDomain))
+	require.Equal(t, []string{"a, b"}, splitTargets("TXT", "a, b"))
+
+	var mu sync.Mutex
+	var created []string
+	updated := map[string]string{}
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		var req DNSRecordRequest
+		if r.Method != http.MethodGet {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			created = append(created, req.Content)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"new"}}`))
+		case http.MethodPut:
+			updated[path.Base(r.URL.Path)] = req.Content
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"result":[` +
+				`{"id":"keep","type":"A","content":"192.0.2.2","ttl":1},` +
+				`{"id":"old","type":"A","content":"198.51.100.1","ttl":1},` +
+				`{"id":"txt","type":"TXT","content":"hello","ttl":1}]}`))
+		}
+	})
+	comp := &Companion{cfg: Config{Domains: doms}, cf: cf}
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "www.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+
+	// The stale record is repointed, the matching one kept and the missing
+	// target created.
+	require.Equal(t, map[string]string{"old": "192.0.2.1"}, updated)
+	require.Equal(t, []string{"192.0.2.3"}, created)
+	require.Equal(t, SyncResult{Created: 1, Updated: 1, Skipped: 1}, *res)
+
+	assigned, unused := assignTargets([]DNSRecord{{ID: "x", Type: "A", Content: "198.51.100.1"}, {ID: "y", Type: "A", Content: "198.51.100.2"}}, "A", []string{"192.0.2.1"})
+	require.Equal(t, [][]DNSRecord{{{ID: "x", Type: "A", Content: "198.51.100.1"}}}, assigned)
+	require.Equal(t, []DNSRecord{{ID: "y", Type: "A", Content: "198.51.100.2"}}, unused)
+}
+
+func TestSRVRecords(t *testing.T) {
+	srv, err := labelSRVMapping(map[string]string{labelSRVService: "minecraft", labelSRVPort: "25565", labelSRVWeight: "5"})
+	require.NoError(t, err)
+	require.Equal(t, &SRVMapping{Service: "_minecraft", Proto: "_tcp", Port: 25565, Weight: 5}, srv)
+	srv, err = labelSRVMapping(map[string]string{})
+	require.NoError(t, err)
+	require.Nil(t, srv)
+	_, err = labelSRVMapping(map[string]string{labelSRVPort: "25565"})
+	require.EqualError(t, err, "cloudflare.companion.srv_service is required with cloudflare.companion.srv_port")
+	_, err = labelSRVMapping(map[string]string{labelSRVService: "_sip", labelSRVPort: "70000"})
+	require.EqualError(t, err, `cloudflare.companion.srv_port must be a port between 1 and 65535, got "70000"`)
+	_, err = labelSRVMapping(map[string]string{labelSRVService: "_sip", labelSRVPort: "5060", labelSRVPriority: "-1"})
+	require.EqualError(t, err, `cloudflare.companion.srv_priority must be between 0 and 65535, got "-1"`)
+
+	var mu sync.Mutex
+	var listed []string
+	var created []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			created = append(created, req)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		listed = append(listed, r.URL.Query().Get("name"))
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"other","type":"SRV","content":"5 25565 old.example.net","priority":10}]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: []DomainConfig{
+		{Name: "example.com", RecordType: "SRV", ZoneID: "zone", TTL: 1, TargetDomain: "host.example.net", Proxied: true},
+	}}, cf: cf}
+
+	res := &SyncResult{}
+	require.False(t, comp.pointDomain(context.Background(), "mc.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, 1, res.Failed)
+
+	mapping := labelMapping(map[string]string{labelSRVService: "_minecraft", labelSRVPort: "25565", labelSRVPriority: "10", labelSRVWeight: "5"}, "Container ID: abc", NewLogger("ERROR"))
+	require.True(t, comp.pointDomain(context.Background(), "mc.example.com", mapping, &SyncResult{}, NewLogger("ERROR")))
+	require.Equal(t, []string{"_minecraft._tcp.mc.example.com"}, listed)
+	require.Len(t, created, 1)
+	require.Equal(t, "_minecraft._tcp.mc.example.com", created[0].Name)
+	require.Equal(t, "5 25565 host.example.net", created[0].Content)
+	require.False(t, created[0].Proxied)
+	require.Equal(t, 10, *created[0].Priority)
+	require.Equal(t, map[string]any{"priority": float64(10), "weight": float64(5), "port": float64(25565), "target": "host.example.net"}, created[0].Data)
+}
+
+func TestProtectedContents(t *testing.T) {
+	var updates int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			updates++
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"Maintenance.example.net"}]}`))
+	})
+	comp := &Companion{cfg: Config{
+		ProtectedContents: []string{"maintenance.example.net"},
+		Domains:           []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+	}, cf: cf}
+	buf := &bytes.Buffer{}
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, res, newBufferLogger(buf)))
+	require.Zero(t, updates)
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.Contains(t, buf.String(), "a.example.com record rec points to protected content Maintenance.example.net, not changing it")
+
+	comp.cfg.ProtectedContents = nil
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.Equal(t, 1, updates)
+}
+
+func TestLockMarkerSkipsLockedRecords(t *testing.T) {
+	var updates int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			updates++
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"old.example.net","comment":"pinned, LOCKED by ops"}]}`))
+	})
+	comp := &Companion{cfg: Config{
+		LockMarker: "locked",
+		Domains:    []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+	}, cf: cf}
+	buf := &bytes.Buffer{}
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, res, newBufferLogger(buf)))
+	require.Zero(t, updates)
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.Contains(t, buf.String(), `a.example.com record rec is locked by its comment "pinned, LOCKED by ops", not changing it`)
+
+	comp.cfg.LockMarker = ""
+	require.True(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	require.Equal(t, 1, updates)
+}
+
+func TestPointDomainWritesDomainsConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	var created []string
+	listing := &sync.WaitGroup{}
+	listing.Add(3)
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		zone := strings.Split(r.URL.Path, "/")[2]
+		if r.Method == http.MethodPost {
+			mu.Lock()
+			created = append(created, zone)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		// Every matching domain lists its records before any of them is
+		// answered, which only happens when they run concurrently.
+		listing.Done()
+		listing.Wait()
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{cfg: Config{
+		DomainWriteConcurrency: 3,
+		Domains: []DomainConfig{
+			{Name: "example.com", RecordType: "CNAME", ZoneID: "zone1", TargetDomain: "lb.example.net"},
+			{Name: "a.example.com", RecordType: "CNAME", ZoneID: "zone2", TargetDomain: "lb.example.net"},
+			{Name: "example.org", RecordType: "CNAME", ZoneID: "zone3", TargetDomain: "lb.example.net"},
+			{Name: "example.com", RecordType: "CNAME", ZoneID: "zone4", TargetDomain: "lb.example.net"},
+		},
+	}, cf: cf}
+	res := &SyncResult{}
+	require.True(t, comp.pointDomain(context.Background(), "x.a.example.com", Mapping{Source: 1}, res, NewLogger("ERROR")))
+	require.Equal(t, SyncResult{Created: 3}, *res)
+	require.ElementsMatch(t, []string{"zone1", "zone2", "zone4"}, created)
+}
+
+func TestShutdownDrainsInFlightSync(t *testing.T) {
+	listed := make(chan struct{}, 1)
+	release := make(chan struct{})
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		listed <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	// run starts a sync, shuts down while its first request is in flight and
+	// calls afterShutdown before letting the request complete.
+	run := func(drain context.Context, afterShutdown func()) SyncResult {
+		comp := &Companion{
+			cfg: Config{
+				Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+			},
+			cf:     cf,
+			synced: map[string]int{},
+			drain:  drain,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		results := make(chan SyncResult)
+		go func() {
+			results <- comp.SyncMappings(ctx, map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+		}()
+		<-listed
+		cancel()
+		afterShutdown()
+		time.Sleep(20 * time.Millisecond)
+		release <- struct{}{}
+		return <-results
+	}
+
+	drain, cancelDrain := context.WithCancel(context.Background())
+	require.Equal(t, SyncResult{Created: 1}, run(drain, func() {}))
+
+	// Without a drain context shutdown interrupts the in-flight request.
+	require.Equal(t, 1, run(nil, func() {}).Failed)
+
+	// Once the grace period expires the in-flight request is cancelled too.
+	require.Equal(t, 1, run(drain, cancelDrain).Failed)
+
+	// No new syncs start after shutdown.
+	require.Equal(t, SyncResult{}, (&Companion{}).SyncMappings(drain, map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR")))
+}
+
+func TestWaitWithGrace(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	require.True(t, waitWithGrace(wg, time.Second))
+
+	wg.Add(1)
+	require.False(t, waitWithGrace(wg, 10*time.Millisecond))
+	wg.Done()
+	require.True(t, waitWithGrace(wg, time.Second))
+
+	// Without a grace period wg is waited for rather than given up on.
+	wg = &sync.WaitGroup{}
+	wg.Add(1)
+	time.AfterFunc(10*time.Millisecond, wg.Done)
+	require.True(t, waitWithGrace(wg, 0))
+}
+
+func TestShutdownGraceMustNotBeNegative(t *testing.T) {
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+
+	t.Setenv("SHUTDOWN_GRACE_SECONDS", "0")
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, 0, cfg.ShutdownGraceSecs)
+
+	t.Setenv("SHUTDOWN_GRACE_SECONDS", "-1")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, "SHUTDOWN_GRACE_SECONDS must not be negative")
+}
+
+func TestConcurrentSyncsCreateRecordOnce(t *testing.T) {
+	var mu sync.Mutex
+	var records []string
+	var creates int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			creates++
+			records = append(records, "rec")
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec","content":"lb.example.net"}}`))
+			return
+		}
+		if len(records) == 0 {
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","content":"lb.example.net"}]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			VerifySampleRate: 1,
+			Domains:          []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+		sample: func() float64 { return 0 },
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, creates)
+}
+
+func TestTraefikProxiedAnnotation(t *testing.T) {
+	traefik := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte(`[
+				{"name":"on@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"},
+				{"name":"off@docker","rule":"Host(` + "`b.example.com`" + `)","status":"enabled"},
+				{"name":"plain@docker","rule":"Host(` + "`c.example.com`" + `)","status":"enabled"}
+			]`))
+		case "/api/http/routers/on@docker":
+			_, _ = w.Write([]byte(`{"name":"on@docker","annotations":{"cloudflare.proxied":"true"}}`))
+		case "/api/http/routers/off@docker":
+			_, _ = w.Write([]byte(`{"name":"off@docker","annotations":{"cloudflare.proxied":false}}`))
+		case "/api/http/routers/plain@docker":
+			_, _ = w.Write([]byte(`{"name":"plain@docker"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer traefik.Close()
+
+	comp := &Companion{cfg: Config{
+		TraefikPollURL:           traefik.URL,
+		TraefikProxiedAnnotation: "annotations.cloudflare.proxied",
+		IncludedHosts:            matchAll,
+	}}
+	proxied, dnsOnly := true, false
+	mappings, ok := comp.checkTraefik(context.Background(), NewLogger("ERROR"))
+	require.True(t, ok)
+	require.Equal(t, map[string]Mapping{
+		"a.example.com": {Source: 2, Proxied: &proxied},
+		"b.example.com": {Source: 2, Proxied: &dnsOnly},
+		"c.example.com": {Source: 2},
+	}, mappings)
+}
+
+func TestTraefikServiceTarget(t *testing.T) {
+	traefik := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte(`[
+				{"name":"single@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled","service":"single","provider":"docker"},
+				{"name":"multi@docker","rule":"Host(` + "`b.example.com`" + `)","status":"enabled","service":"multi@docker","provider":"docker"}
+			]`))
+		case "/api/http/services/single@docker":
+			_, _ = w.Write([]byte(`{"name":"single@docker","loadBalancer":{"servers":[{"url":"http://192.0.2.20:8080"}]}}`))
+		case "/api/http/services/multi@docker":
+			_, _ = w.Write([]byte(`{"name":"multi@docker","loadBalancer":{"servers":[{"url":"http://192.0.2.21"},{"url":"http://192.0.2.22"}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer traefik.Close()
+
+	var created []DNSRecordRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			created = append(created, req)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			TraefikPollURL:          traefik.URL,
+			TraefikUseServiceTarget: true,
+			IncludedHosts:           matchAll,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+	logger := NewLogger("ERROR")
+
+	mappings, ok := comp.checkTraefik(context.Background(), logger)
+	require.True(t, ok)
+	require.Equal(t, map[string]Mapping{
+		"a.example.com": {Source: 2, Target: "192.0.2.20"},
+		"b.example.com": {Source: 2},
+	}, mappings)
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": mappings["a.example.com"]}, logger)
+	require.Len(t, created, 1)
+	require.Equal(t, "A", created[0].Type)
+	require.Equal(t, "192.0.2.20", created[0].Content)
+}
+
+func TestSwarmServiceCreateAndRemoveEvents(t *testing.T) {
+	var zero uint64
+	idle := newSwarmService("svc-idle", map[string]string{"traefik.http.routers.b.rule": "Host(`b.example.com`)"})
+	idle.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &zero}
+	comp := &Companion{
+		cfg: Config{DockerSwarmMode: true, DockerSwarmIgnoreStopped: true, TraefikVersion: "2", TraefikExposedByDefault: true, IncludedHosts: matchAll},
+		docker: &fakeDocker{services: []swarm.Service{
+			newSwarmService("svc-new", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}),
+			idle,
+		}},
+	}
+	buf := &bytes.Buffer{}
+	logger := newBufferLogger(buf)
+
+	event := events.Message{Type: events.ServiceEventType, Action: "create", Actor: events.Actor{ID: "svc-new"}}
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.processDockerEvent(context.Background(), event, logger))
+
+	event.Actor.ID = "svc-idle"
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, logger))
+
+	event.Actor.ID = "svc-missing"
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, logger))
+	require.Contains(t, buf.String(), "failed to inspect service svc-missing on create event")
+
+	event = events.Message{Type: events.ServiceEventType, Action: "remove", Actor: events.Actor{ID: "svc-new"}}
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, logger))
+}
+
+func TestSwarmServiceRemoveDeletesRecords(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			deleted = append(deleted, r.URL.Path)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net"},{"id":"other","type":"CNAME","content":"elsewhere.example.net"}]}`))
+	})
+	rule := func(host string) map[string]string {
+		return map[string]string{"traefik.http.routers.r.rule": "Host(`" + host + "`)"}
+	}
+	comp := &Companion{
+		cfg: Config{
+			DockerSwarmMode:         true,
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{"a.example.com": 1, "shared.example.com": 1},
+		plan:   NewPlan(),
+		docker: &fakeDocker{services: []swarm.Service{
+			newSwarmService("svc-a", rule("a.example.com")),
+			newSwarmService("svc-shared", rule("shared.example.com")),
+			newSwarmService("svc-shared-too", rule("shared.example.com")),
+		}},
+	}
+	_, err := comp.GetInitialMappings(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+
+	event := events.Message{Type: events.ServiceEventType, Action: "remove", Actor: events.Actor{ID: "svc-a"}}
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+	require.Equal(t, []string{"/zones/zone/dns_records/rec"}, deleted)
+	require.Equal(t, []string{"a.example.com"}, comp.plan.Hosts(planDelete))
+	require.Equal(t, map[string]int{"shared.example.com": 1}, comp.synced)
+
+	// A host another service still routes keeps its records.
+	event.Actor.ID = "svc-shared"
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+	require.Len(t, deleted, 1)
+
+	comp.cfg.DryRun = true
+	event.Actor.ID = "svc-shared-too"
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+	require.Len(t, deleted, 1)
+	require.Equal(t, []string{"a.example.com", "shared.example.com"}, comp.plan.Hosts(planDelete))
+}
+
+func TestSwarmServiceScaledToZeroDeletesRecords(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			deleted = append(deleted, r.URL.Path)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net"}]}`))
+	})
+	svc := newSwarmService("svc-a", map[string]string{"traefik.http.routers.r.rule": "Host(`a.example.com`)"})
+	docker := &fakeDocker{services: []swarm.Service{svc}}
+	comp := &Companion{
+		cfg: Config{
+			DockerSwarmMode:          true,
+			DockerSwarmIgnoreStopped: true,
+			TraefikVersion:           "2",
+			TraefikExposedByDefault:  true,
+			IncludedHosts:            matchAll,
+			Domains:                  []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{"a.example.com": 1},
+		plan:   NewPlan(),
+		docker: docker,
+	}
+	_, err := comp.GetInitialMappings(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+
+	var zero uint64
+	docker.services[0].Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &zero}
+	event := events.Message{Type: events.ServiceEventType, Action: "update", Actor: events.Actor{ID: "svc-a"}}
+	require.Empty(t, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+	require.Equal(t, []string{"/zones/zone/dns_records/rec"}, deleted)
+	require.Empty(t, comp.synced)
+
+	// Scaling back up tracks the service again.
+	one := uint64(1)
+	docker.services[0].Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &one}
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+	require.Contains(t, comp.services, "svc-a")
+}
+
+func TestSwarmServiceFilterMatchesTaskTemplateLabels(t *testing.T) {
+	rule := map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"}
+	templated := newSwarmService("svc-templated", rule)
+	templated.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Labels: map[string]string{"traefik.constraint": "public"}}
+	other := newSwarmService("svc-other", map[string]string{"traefik.http.routers.b.rule": "Host(`b.example.com`)"})
+	other.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Labels: map[string]string{"traefik.constraint": "internal"}}
+	comp := &Companion{
+		cfg: Config{
+			DockerSwarmMode:         true,
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			TraefikFilter:           regexp.MustCompile("public"),
+			TraefikFilterKey:        regexp.MustCompile("traefik.constraint"),
+		},
+		docker: &fakeDocker{services: []swarm.Service{templated, other, newSwarmService("svc-bare", rule)}},
+	}
+
+	mappings, err := comp.GetInitialMappings(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, mappings)
+
+	event := events.Message{Type: events.ServiceEventType, Action: "create", Actor: events.Actor{ID: "svc-templated"}}
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 1}}, comp.processDockerEvent(context.Background(), event, NewLogger("ERROR")))
+}
+
+func TestExpandTargetTemplate(t *testing.T) {
+	dom := DomainConfig{Name: "example.com", RecordType: "CNAME", TargetDomain: "lb.example.net"}
+	tpl := "{subdomain}.internal.example.com"
+	require.Equal(t, "app.internal.example.com", expandTargetTemplate(tpl, "app.example.com", dom))
+	require.Equal(t, "api.eu.internal.example.com", expandTargetTemplate(tpl, "api.eu.example.com", dom))
+	require.Equal(t, "", expandTargetTemplate(tpl, "example.com", dom))
+	require.Equal(t, "app.example.com.edge.example.net", expandTargetTemplate("{host}.edge.example.net", "app.example.com", dom))
+	require.Equal(t, "origin.example.com", expandTargetTemplate("origin.{domain}", "example.com", dom))
+	require.Equal(t, "", expandTargetTemplate("{host}", "app.example.com", dom))
+	require.Equal(t, "", expandTargetTemplate("", "app.example.com", dom))
+	dom.RecordType = "A"
+	require.Equal(t, "", expandTargetTemplate(tpl, "app.example.com", dom))
+
+	require.NoError(t, validateTargetTemplate("{subdomain}.{domain}.{host}"))
+	require.EqualError(t, validateTargetTemplate("{sub}.internal.example.com"), "unknown placeholder {sub}, use {host}, {subdomain} or {domain}")
+}
+
+func TestTargetTemplatePointsHosts(t *testing.T) {
+	var created []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req DNSRecordRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			created = append(created, req.Name+" "+req.Content)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{cfg: Config{
+		TargetTemplate: "{subdomain}.internal.example.com",
+		Domains:        []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+	}, cf: cf}
+	for _, host := range []string{"app.example.com", "example.com"} {
+		require.True(t, comp.pointDomain(context.Background(), host, Mapping{Source: 1}, &SyncResult{}, NewLogger("ERROR")))
+	}
+	require.True(t, comp.pointDomain(context.Background(), "svc.example.com", Mapping{Source: 2, Target: "10.0.0.5"}, &SyncResult{}, NewLogger("ERROR")))
+	require.Equal(t, []string{
+		"app.example.com app.internal.example.com",
+		"example.com lb.example.net",
+		"svc.example.com 10.0.0.5",
+	}, created)
+}
+
+func TestExpandComment(t *testing.T) {
+	now := time.Date(2026, 3, 4, 23, 30, 0, 0, time.FixedZone("X", -2*3600))
+	require.Equal(t, "companion:a.example.com -> lb.example.net via traefik updated 2026-03-05",
+		expandComment("companion:{host} -> {target} via {source} updated {date}", "a.example.com", "lb.example.net", 2, now))
+	require.Equal(t, "docker", expandComment("{source}", "a.example.com", "lb.example.net", 1, now))
+	require.Equal(t, "static comment", expandComment("static comment", "a.example.com", "lb.example.net", 1, now))
+	require.Equal(t, "{unknown} a.example.com", expandComment("{unknown} {host}", "a.example.com", "lb.example.net", 1, now))
+}
+
+func writeTestKeyPair(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "companion"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNewTLSConfigClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir())
+
+	tlsCfg, err := newTLSConfig("", certFile, keyFile, false)
+	require.NoError(t, err)
+	require.Len(t, tlsCfg.Certificates, 1)
+
+	_, err = newTLSConfig("", certFile, "", false)
+	require.EqualError(t, err, "client certificate and key files must be set together")
+
+	_, err = newTLSConfig("", keyFile, certFile, false)
+	require.ErrorContains(t, err, "failed to load client certificate")
+}
+
+func TestNewDockerHTTPClientWithClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir())
+
+	httpClient, ok, err := newDockerHTTPClient(Config{DockerHost: "tcp://docker.example.com:2376", DockerCertFile: certFile, DockerKeyFile: keyFile})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, httpClient.Transport.(*http.Transport).TLSClientConfig.Certificates, 1)
+}
+
+func TestDockerClientOptsPinnedVersion(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Api-Version", "1.47")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer daemon.Close()
+	t.Setenv("DOCKER_HOST", "tcp://"+daemon.Listener.Addr().String())
+	t.Setenv("DOCKER_API_VERSION", "")
+
+	pinned, err := client.NewClientWithOpts(dockerClientOpts(Config{DockerAPIVersion: "1.41"})...)
+	require.NoError(t, err)
+	_, err = pinned.ContainerList(context.Background(), container.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/v1.41/containers/json"}, paths)
+
+	paths = nil
+	negotiated, err := client.NewClientWithOpts(dockerClientOpts(Config{})...)
+	require.NoError(t, err)
+	_, err = negotiated.ContainerList(context.Background(), container.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/_ping", "/v1.47/containers/json"}, paths)
+
+	t.Setenv("DOCKER_API_VERSION", "latest")
+	_, err = LoadConfigFromEnv()
+	require.EqualError(t, err, `DOCKER_API_VERSION must look like 1.41, got "latest"`)
+}
+
+func TestRecordAlreadyExistsFallsBackToUpdate(t *testing.T) {
+	var requests []string
+	lists := 0
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":81057,"message":"Record already exists."}]}`))
+		case http.MethodPut:
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"oob"}}`))
+		default:
+			lists++
+			if lists == 1 {
+				_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"oob","content":"old.example.net"}]}`))
+		}
+	})
+	comp := &Companion{
+		cfg:    Config{Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}}},
+		cf:     cf,
+		synced: map[string]int{},
+		plan:   NewPlan(),
+	}
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+	// The create looks for an identical record, then falls back to updating
+	// the one it found.
+	require.Equal(t, []string{
+		"GET /zones/zone/dns_records",
+		"POST /zones/zone/dns_records",
+		"GET /zones/zone/dns_records",
+		"PUT /zones/zone/dns_records/oob",
+	}, requests)
+	require.Equal(t, map[string]int{"a.example.com": 1}, comp.synced)
+	require.False(t, comp.plan.HasErrors())
+}
+
+func TestDomainHostFilters(t *testing.T) {
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+	t.Setenv("DOMAIN1_INCLUDED_HOST1", `^[a-z]+\.public\.example\.com$`)
+	t.Setenv("DOMAIN2", "example.org")
+	t.Setenv("DOMAIN2_ZONE_ID", "zone2")
+	t.Setenv("DOMAIN2_EXCLUDED_HOST1", `\.public\.example\.org$`)
+
+	doms, err := loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.NoError(t, err)
+	require.Len(t, doms, 2)
+	require.True(t, isDomainHostAllowed("app.public.example.com", doms[0]))
+	require.False(t, isDomainHostAllowed("app.example.com", doms[0]))
+	require.False(t, isDomainHostAllowed("app.public.example.org", doms[1]))
+	require.True(t, isDomainHostAllowed("app.example.org", doms[1]))
+
+	var listed []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		listed = append(listed, r.URL.Query().Get("name"))
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","content":"lb.example.net"}]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: doms}, cf: cf, synced: map[string]int{}}
+	comp.SyncMappings(context.Background(), map[string]Mapping{
+		"app.example.com":        {Source: 2},
+		"app.public.example.com": {Source: 2},
+		"app.public.example.org": {Source: 2},
+	}, NewLogger("ERROR"))
+	require.Equal(t, []string{"app.public.example.com"}, listed)
+
+	t.Setenv("DOMAIN2_EXCLUDED_HOST1", `(`)
+	_, err = loadDomainConfigs(1, "lb.example.net", "CNAME")
+	require.ErrorContains(t, err, "DOMAIN2: invalid EXCLUDED_HOST1 regex")
+}
+
+func TestDomainSuffixMatching(t *testing.T) {
+	require.True(t, domainMatches("api.example.com", "example.com"))
+	require.True(t, domainMatches("example.com", "example.com"))
+	require.False(t, domainMatches("api.notexample.com", "example.com"))
+	require.False(t, domainMatches("example.com.evil.net", "example.com"))
+
+	doms := []DomainConfig{
+		{Name: "example.com", RecordType: "CNAME", ZoneID: "zone1", TargetDomain: "lb.example.net"},
+		{Name: "sub.example.com", RecordType: "CNAME", ZoneID: "zone2", TargetDomain: "lb.example.net"},
+		{Name: "example.org", RecordType: "CNAME", ZoneID: "zone3", TargetDomain: "lb.example.net"},
+	}
+	require.Equal(t, [][2]string{{"example.com", "sub.example.com"}}, overlappingDomains(doms))
+
+	var zones []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		zones = append(zones, strings.Split(r.URL.Path, "/")[2])
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","content":"lb.example.net"}]}`))
+	})
+	comp := &Companion{cfg: Config{Domains: doms, DomainMatchMode: domainMatchAll}, cf: cf, synced: map[string]int{}}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"api.sub.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []string{"zone1", "zone2"}, zones)
+
+	zones = nil
+	comp = &Companion{cfg: Config{Domains: doms, DomainMatchMode: domainMatchLongestSuffix}, cf: cf, synced: map[string]int{}}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"api.sub.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []string{"zone2"}, zones)
+	zones = nil
+	comp.SyncMappings(context.Background(), map[string]Mapping{"www.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []string{"zone1"}, zones)
+}
+
+func TestInitialSyncDelay(t *testing.T) {
+	require.Zero(t, initialSyncDelay(0, 0, nil))
+	require.Equal(t, 10*time.Second, initialSyncDelay(10, 0, nil))
+	require.Equal(t, 15*time.Second, initialSyncDelay(10, 10, func() float64 { return 0.5 }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	require.False(t, sleepContext(ctx, time.Minute))
+	require.Less(t, time.Since(start), time.Second)
+	require.True(t, sleepContext(context.Background(), time.Millisecond))
+}
+
+func TestCustomHostnames(t *testing.T) {
+	var requests []string
+	var created CustomHostnameRequest
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost:
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"ch1","hostname":"shop.example.com","status":"pending"}}`))
+		case r.URL.Query().Get("hostname") == "known.example.com":
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"ch0","hostname":"known.example.com","status":"active"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+		}
+	})
+	comp := &Companion{
+		cfg: Config{
+			CustomHostnames:         true,
+			CustomHostnameSSLMethod: "txt",
+			CustomHostnameSSLType:   "dv",
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+		plan:   NewPlan(),
+	}
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"shop.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []string{"GET /zones/zone/custom_hostnames", "POST /zones/zone/custom_hostnames"}, requests)
+	require.Equal(t, CustomHostnameRequest{Hostname: "shop.example.com", SSL: CustomHostnameSSL{Method: "txt", Type: "dv"}}, created)
+
+	requests = nil
+	comp.SyncMappings(context.Background(), map[string]Mapping{"known.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []string{"GET /zones/zone/custom_hostnames"}, requests)
+	require.Equal(t, []string{"shop.example.com"}, comp.plan.Hosts(planCreate))
+	require.Equal(t, []string{"known.example.com"}, comp.plan.Hosts(planSkip))
+}
+
+func TestCustomHostnamesOutsideTheZone(t *testing.T) {
+	var requests []string
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("hostname"))
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"ch1","hostname":"shop.customer.net","status":"pending"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			CustomHostnames: true,
+			Domains: []DomainConfig{{
+				Name:          "saas.example.com",
+				RecordType:    "CNAME",
+				ZoneID:        "zone",
+				TargetDomain:  "fallback.saas.example.com",
+				IncludedHosts: []*regexp.Regexp{regexp.MustCompile(`.*\.customer\.net`)},
+			}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+
+	res := comp.SyncMappings(context.Background(), map[string]Mapping{"shop.customer.net": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, SyncResult{Created: 1}, res)
+	require.Equal(t, []string{"GET /zones/zone/custom_hostnames shop.customer.net", "POST /zones/zone/custom_hostnames "}, requests)
+
+	requests = nil
+	res = comp.SyncMappings(context.Background(), map[string]Mapping{"shop.other.net": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, SyncResult{}, res)
+	require.Empty(t, requests)
+
+	// Without custom hostnames the filters only narrow hosts under the domain.
+	comp.cfg.CustomHostnames = false
+	require.Equal(t, "it is not under the domain", comp.domainSkipReason("shop.customer.net", "", comp.cfg.Domains[0]))
+}
+
+func TestIgnoreLabel(t *testing.T) {
+	comp := &Companion{cfg: Config{TraefikExposedByDefault: true, IncludedHosts: matchAll, DockerIgnoreLabel: labelIgnore}}
+	logger := NewLogger("ERROR")
+	labels := map[string]string{
+		"traefik.http.routers.a.rule": "Host(`a.example.com`)",
+		labelIgnore:                   "true",
+	}
+	require.Empty(t, comp.checkContainerT2("c1", labels, logger))
+	require.Empty(t, comp.checkServiceT2("s1", labels, nil, logger))
+
+	labels[labelIgnore] = "false"
+	require.Len(t, comp.checkContainerT2("c1", labels, logger), 1)
+
+	comp.cfg.DockerIgnoreLabel = "dns.skip"
+	labels["dns.skip"] = "TRUE"
+	require.Empty(t, comp.checkContainerT2("c1", labels, logger))
+	require.Empty(t, comp.checkContainerT1("c1", map[string]string{"traefik.frontend.rule": "Host:a.example.com", "dns.skip": "true"}, logger))
+}
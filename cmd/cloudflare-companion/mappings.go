@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// mappingsReport runs discovery for PRINT_MAPPINGS without writing to
+// Cloudflare. It lists every host found with its source and the domains it
+// would be written to, and every dropped host with the filter that dropped it.
+func (c *Companion) mappingsReport(ctx context.Context, logger *Logger) (string, error) {
+	c.dropped = map[string]string{}
+	defer func() { c.dropped = nil }()
+	mappings, err := c.GetInitialMappings(ctx, logger)
+	if err != nil {
+		return "", err
+	}
+	dropped := maps.Clone(c.dropped)
+
+	var b strings.Builder
+	b.WriteString("Mappings:\n")
+	for _, host := range slices.Sorted(maps.Keys(mappings)) {
+		domains, reason := c.mappingDomains(host, mappings[host])
+		if len(domains) == 0 {
+			dropped[host] = reason
+			continue
+		}
+		fmt.Fprintf(&b, "  %-40s %-8s %s\n", host, sourceName(mappings[host].Source), strings.Join(domains, ", "))
+	}
+	b.WriteString("Dropped:\n")
+	for _, host := range slices.Sorted(maps.Keys(dropped)) {
+		fmt.Fprintf(&b, "  %-40s %s\n", host, dropped[host])
+	}
+	return b.String(), nil
+}
+
+// mappingDomains returns the domains host would be written to. When there
+// are none it also returns why.
+func (c *Companion) mappingDomains(host string, mapping Mapping) ([]string, string) {
+	var domains []string
+	reason := "no configured domain matches"
+	if mapping.ZoneID != "" && !c.zoneConfigured(mapping.ZoneID) {
+		reason = fmt.Sprintf("pinned to zone %s, which no configured domain uses", mapping.ZoneID)
+	}
+	for _, dom := range c.cfg.Domains {
+		dom = withMappingExclusions(dom, mapping)
+		skip := c.domainSkipReason(host, mapping.ZoneID, dom)
+		if skip == "" {
+			domains = append(domains, dom.Name)
+		} else if domainMatches(host, dom.Name) {
+			reason = fmt.Sprintf("skipped for %s because %s", dom.Name, skip)
+		}
+	}
+	return domains, reason
+}
+
+// checkSyncedDomainMatches runs checkDomainMatches over every synced host.
+// It runs after the initial sync and each reconcile, which cover all the
+// hosts, rather than after the partial syncs of events and polls.
+func (c *Companion) checkSyncedDomainMatches(logger *Logger) {
+	c.syncedM.Lock()
+	synced := maps.Clone(c.syncedMappings)
+	c.syncedM.Unlock()
+	c.checkDomainMatches(synced, logger)
+}
+
+// checkDomainMatches counts the hosts written to each configured domain,
+// keyed by domainMatchKey as a name can be configured in several zones. A
+// domain matching no host usually has a typo in its name or too strict
+// filters, so it is warned about when first seen without hosts.
+func (c *Companion) checkDomainMatches(mappings map[string]Mapping, logger *Logger) {
+	counts := make(map[string]int, len(c.cfg.Domains))
+	for _, dom := range c.cfg.Domains {
+		counts[domainMatchKey(dom)] = 0
+	}
+	for host, mapping := range mappings {
+		for _, dom := range c.cfg.Domains {
+			if c.domainSkipReason(host, mapping.ZoneID, withMappingExclusions(dom, mapping)) == "" {
+				counts[domainMatchKey(dom)]++
+			}
+		}
+	}
+
+	c.domainMatchesM.Lock()
+	previous := c.domainMatches
+	c.domainMatches = counts
+	c.domainMatchesM.Unlock()
+	for _, dom := range c.cfg.Domains {
+		key := domainMatchKey(dom)
+		if counts[key] > 0 {
+			continue
+		}
+		if prev, ok := previous[key]; !ok || prev > 0 {
+			logger.Warnf("Domain %s in zone %s matched none of the %d synced hosts, check the domain name and host filters", dom.Name, dom.ZoneID, len(mappings))
+		}
+	}
+	logger.Debugf("Hosts matched per domain: %v", counts)
+}
+
+// domainMatchKey identifies dom in the counts of checkDomainMatches.
+func domainMatchKey(dom DomainConfig) string {
+	return dom.ZoneID + "/" + dom.Name
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMappingsReport(t *testing.T) {
+	comp := &Companion{
+		cfg: Config{
+			EnableDockerPoll:        true,
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			ExcludedHosts:           []*regexp.Regexp{regexp.MustCompile(`^internal\.`)},
+			DomainMatchMode:         domainMatchLongestSuffix,
+			Domains: []DomainConfig{
+				{Name: "example.com", ZoneID: "zone1", TargetDomain: "lb.example.net", ExcludedSubDomains: []string{"lan"}},
+				{Name: "dev.example.com", ZoneID: "zone2", TargetDomain: "lb.example.net"},
+			},
+		},
+		docker: &fakeDocker{containers: []container.InspectResponse{
+			newContainer("c1", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`) || Host(`internal.example.com`)"}),
+			newContainer("c2", map[string]string{"traefik.http.routers.b.rule": "Host(`x.lan.example.com`) || Host(`b.other.org`) || Host(`c.dev.example.com`)"}),
+		}},
+	}
+
+	report, err := comp.mappingsReport(context.Background(), NewLogger("ERROR"))
+	require.NoError(t, err)
+	require.Equal(t, `Mappings:
+  a.example.com                            docker   example.com
+  c.dev.example.com                        docker   dev.example.com
+Dropped:
+  b.other.org                              no configured domain matches
+  internal.example.com                     matched by the exclude host filters
+  x.lan.example.com                        skipped for example.com because it falls under an excluded sub domain
+`, report)
+	require.Nil(t, comp.dropped)
+}
+
+func TestCheckDomainMatches(t *testing.T) {
+	comp := &Companion{cfg: Config{Domains: []DomainConfig{
+		{Name: "example.com", ZoneID: "zone1", TargetDomain: "lb.example.net"},
+		{Name: "example.com", ZoneID: "zone2", TargetDomain: "lb.example.net"},
+		{Name: "exmaple.org", ZoneID: "zone3", TargetDomain: "lb.example.net"},
+	}}}
+	buf := &bytes.Buffer{}
+	comp.checkDomainMatches(map[string]Mapping{"a.example.com": {Source: 1}, "b.example.com": {Source: 2, ZoneID: "zone2"}}, newBufferLogger(buf))
+	require.Equal(t, map[string]int{"zone1/example.com": 1, "zone2/example.com": 2, "zone3/exmaple.org": 0}, comp.domainMatches)
+	require.Contains(t, buf.String(), "Domain exmaple.org in zone zone3 matched none of the 2 synced hosts")
+	require.NotContains(t, buf.String(), "Domain example.com in zone")
+
+	// The warning is not repeated while the domain keeps matching nothing,
+	// but is logged again once a matched domain loses its hosts.
+	buf.Reset()
+	comp.checkDomainMatches(map[string]Mapping{}, newBufferLogger(buf))
+	require.NotContains(t, buf.String(), "Domain exmaple.org")
+	require.Contains(t, buf.String(), "Domain example.com in zone zone1 matched none of the 0 synced hosts")
+	require.Contains(t, buf.String(), "Domain example.com in zone zone2 matched none of the 0 synced hosts")
+
+	rec := httptest.NewRecorder()
+	comp.adminHandler(NewLogger("ERROR")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/domains", nil))
+	require.JSONEq(t, `{"zone1/example.com":0,"zone2/example.com":0,"zone3/exmaple.org":0}`, rec.Body.String())
+}
+
+func TestCheckSyncedDomainMatchesCountsEverySyncedHost(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net","ttl":1}]}`))
+	})
+	comp := &Companion{
+		cfg: Config{Domains: []DomainConfig{
+			{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 1},
+			{Name: "example.org", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 1},
+		}},
+		cf:     cf,
+		synced: map[string]int{},
+	}
+	buf := &bytes.Buffer{}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, newBufferLogger(buf))
+	comp.checkSyncedDomainMatches(newBufferLogger(buf))
+	require.Equal(t, map[string]int{"zone/example.com": 1, "zone/example.org": 0}, comp.domainMatches)
+	require.Contains(t, buf.String(), "Domain example.org in zone zone matched none of the 1 synced hosts")
+
+	// The partial sync of a Traefik poll leaves the counts alone, the next
+	// check counts its hosts along with the ones synced before.
+	buf.Reset()
+	comp.SyncMappings(context.Background(), map[string]Mapping{"b.example.org": {Source: 2}}, newBufferLogger(buf))
+	require.Equal(t, map[string]int{"zone/example.com": 1, "zone/example.org": 0}, comp.domainMatches)
+	comp.checkSyncedDomainMatches(newBufferLogger(buf))
+	require.Equal(t, map[string]int{"zone/example.com": 1, "zone/example.org": 1}, comp.domainMatches)
+	require.NotContains(t, buf.String(), "matched none")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	requestResultSuccess     = "success"
+	requestResultClientError = "client_error"
+	requestResultRateLimited = "rate_limited"
+	requestResultServerError = "server_error"
+	requestResultError       = "error"
+)
+
+var requestDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func requestResult(statusCode int) string {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return requestResultRateLimited
+	case statusCode >= 500:
+		return requestResultServerError
+	case statusCode >= 400:
+		return requestResultClientError
+	}
+	return requestResultSuccess
+}
+
+type requestSeries struct {
+	op     string
+	result string
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// requestMetrics is a histogram of Cloudflare request durations by
+// operation and result, exposed in the Prometheus text format.
+type requestMetrics struct {
+	mu     sync.Mutex
+	series map[requestSeries]*histogram
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{series: map[requestSeries]*histogram{}}
+}
+
+// Observe records one request. It is a no-op on a nil requestMetrics.
+func (m *requestMetrics) Observe(op string, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := requestSeries{op: op, result: result}
+	h, ok := m.series[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(requestDurationBuckets))}
+		m.series[key] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range requestDurationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *requestMetrics) Write(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]requestSeries, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b requestSeries) int {
+		return cmp.Or(cmp.Compare(a.op, b.op), cmp.Compare(a.result, b.result))
+	})
+
+	const name = "cloudflare_request_duration_seconds"
+	if _, err := fmt.Fprintf(w, "# HELP %s Duration of Cloudflare API requests by operation and result.\n# TYPE %s histogram\n", name, name); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		h := m.series[key]
+		labels := fmt.Sprintf("operation=%q,result=%q", key.op, key.result)
+		for i, le := range requestDurationBuckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), h.buckets[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n%s_sum{%s} %g\n%s_count{%s} %d\n",
+			name, labels, h.count, name, labels, h.sum, name, labels, h.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMetricsWrite(t *testing.T) {
+	metrics := newRequestMetrics()
+	metrics.Observe("list", requestResultSuccess, 80*time.Millisecond)
+	metrics.Observe("list", requestResultSuccess, 3*time.Second)
+	metrics.Observe("create", requestResultRateLimited, 20*time.Millisecond)
+
+	out := &strings.Builder{}
+	require.NoError(t, metrics.Write(out))
+	require.Contains(t, out.String(), "# TYPE cloudflare_request_duration_seconds histogram\n")
+	require.Contains(t, out.String(), `cloudflare_request_duration_seconds_bucket{operation="create",result="rate_limited",le="0.05"} 1`+"\n")
+	require.Contains(t, out.String(), `cloudflare_request_duration_seconds_bucket{operation="list",result="success",le="0.05"} 0`+"\n"+
+		`cloudflare_request_duration_seconds_bucket{operation="list",result="success",le="0.1"} 1`+"\n")
+	require.Contains(t, out.String(), `cloudflare_request_duration_seconds_bucket{operation="list",result="success",le="5"} 2`+"\n")
+	require.Contains(t, out.String(), `cloudflare_request_duration_seconds_count{operation="list",result="success"} 2`+"\n")
+	require.Less(t, strings.Index(out.String(), `operation="create"`), strings.Index(out.String(), `operation="list"`))
+
+	var nilMetrics *requestMetrics
+	nilMetrics.Observe("list", requestResultSuccess, time.Second)
+	require.NoError(t, nilMetrics.Write(out))
+}
+
+func TestCloudflareRequestMetrics(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"rate limited"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{metrics: newRequestMetrics()}
+	cf.SetMetrics(comp.metrics)
+
+	_, err := cf.ListDNSRecords(context.Background(), "zone", "a.example.com")
+	require.NoError(t, err)
+	require.Error(t, cf.UpdateDNSRecord(context.Background(), "zone", "rec", DNSRecordRequest{}))
+
+	rec := httptest.NewRecorder()
+	comp.adminHandler(NewLogger("ERROR")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `cloudflare_request_duration_seconds_count{operation="list",result="success"} 1`)
+	require.Contains(t, rec.Body.String(), `cloudflare_request_duration_seconds_count{operation="update",result="rate_limited"} 1`)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+	planSkip   = "skip"
+)
+
+var planActions = []string{planCreate, planUpdate, planDelete}
+
+type Plan struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]struct{}
+	errors  map[string]string
+}
+
+type PlanReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Created []string          `json:"created"`
+	Updated []string          `json:"updated"`
+	Deleted []string          `json:"deleted"`
+	Skipped []string          `json:"skipped"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// SyncResult counts the record changes of a single SyncMappings call.
+type SyncResult struct {
+	Created     int
+	Updated     int
+	Skipped     int
+	Failed      int
+	FailedHosts []string
+}
+
+func (r *SyncResult) add(action string) {
+	switch action {
+	case planCreate:
+		r.Created++
+	case planUpdate:
+		r.Updated++
+	case planSkip:
+		r.Skipped++
+	}
+}
+
+func (r *SyncResult) fail(host string) {
+	if slices.Contains(r.FailedHosts, host) {
+		return
+	}
+	r.Failed++
+	r.FailedHosts = append(r.FailedHosts, host)
+}
+
+// changes counts the record writes, as limited by MAX_CHANGES_PER_RUN.
+func (r SyncResult) changes() int {
+	return r.Created + r.Updated
+}
+
+func (r SyncResult) Changed() bool {
+	return r.Created > 0 || r.Updated > 0 || r.Failed > 0
+}
+
+func (r SyncResult) String() string {
+	out := fmt.Sprintf("created %d, updated %d, skipped %d, failed %d", r.Created, r.Updated, r.Skipped, r.Failed)
+	if len(r.FailedHosts) > 0 {
+		out += " (" + strings.Join(r.FailedHosts, ", ") + ")"
+	}
+	return out
+}
+
+func NewPlan() *Plan {
+	return &Plan{buckets: map[string]map[string]struct{}{}, errors: map[string]string{}}
+}
+
+func (p *Plan) Add(action string, host string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.buckets[action] == nil {
+		p.buckets[action] = map[string]struct{}{}
+	}
+	p.buckets[action][host] = struct{}{}
+}
+
+func (p *Plan) Fail(host string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors[host] = err.Error()
+}
+
+func (p *Plan) HasErrors() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.errors) > 0
+}
+
+func (p *Plan) Report(dryRun bool) PlanReport {
+	p.mu.Lock()
+	errs := make(map[string]string, len(p.errors))
+	for host, msg := range p.errors {
+		errs[host] = msg
+	}
+	p.mu.Unlock()
+	return PlanReport{
+		DryRun:  dryRun,
+		Created: p.Hosts(planCreate),
+		Updated: p.Hosts(planUpdate),
+		Deleted: p.Hosts(planDelete),
+		Skipped: p.Hosts(planSkip),
+		Errors:  errs,
+	}
+}
+
+func (p *Plan) WriteFile(path string, dryRun bool) error {
+	data, err := json.MarshalIndent(p.Report(dryRun), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func (p *Plan) Hosts(action string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hosts := make([]string, 0, len(p.buckets[action]))
+	for host := range p.buckets[action] {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (p *Plan) Summary() string {
+	counts := make([]string, 0, len(planActions))
+	lines := make([]string, 0, len(planActions))
+	for _, action := range planActions {
+		hosts := p.Hosts(action)
+		counts = append(counts, fmt.Sprintf("%s %d", action, len(hosts)))
+		if len(hosts) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s: %s", action, strings.Join(hosts, ", ")))
+		}
+	}
+	return strings.Join(append([]string{"would " + strings.Join(counts, ", ")}, lines...), "\n")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanSummary(t *testing.T) {
+	plan := NewPlan()
+	plan.Add(planCreate, "b.example.com")
+	plan.Add(planCreate, "a.example.com")
+	plan.Add(planCreate, "a.example.com")
+	plan.Add(planUpdate, "c.example.com")
+
+	require.Equal(t, "would create 2, update 1, delete 0\n"+
+		"  create: a.example.com, b.example.com\n"+
+		"  update: c.example.com", plan.Summary())
+	require.Equal(t, "would create 0, update 0, delete 0", NewPlan().Summary())
+}
+
+func TestPlanOutputMatchesRunOutcome(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(name, "new."):
+			_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+		case r.Method == http.MethodGet && strings.HasPrefix(name, "ok."):
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r1","content":"lb.example.net"}]}`))
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"r2","content":"old.example.net"}]}`))
+		case r.Method == http.MethodPost:
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"r3"}}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":1004,"message":"DNS Validation Error"}]}`))
+		}
+	})
+	output := filepath.Join(t.TempDir(), "plan.json")
+	comp := &Companion{
+		cfg: Config{
+			PlanOutput: output,
+			Domains:    []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+		plan:   NewPlan(),
+	}
+	logger := NewLogger("ERROR")
+
+	result := comp.SyncMappings(context.Background(), map[string]Mapping{
+		"new.example.com":   {Source: 1},
+		"ok.example.com":    {Source: 1},
+		"stale.example.com": {Source: 2},
+	}, logger)
+	require.Equal(t, SyncResult{Created: 1, Skipped: 1, Failed: 1, FailedHosts: []string{"stale.example.com"}}, result)
+	require.Equal(t, "created 1, updated 0, skipped 1, failed 1 (stale.example.com)", result.String())
+	require.Equal(t, 1, comp.FinishRun(logger))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"dry_run": false,
+		"created": ["new.example.com"],
+		"updated": [],
+		"deleted": [],
+		"skipped": ["ok.example.com"],
+		"errors": {"stale.example.com": "cloudflare update failed: 1004 DNS Validation Error"}
+	}`, string(data))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// RunReconciler periodically rediscovers every host and syncs it again, so
+// missed Docker events and out-of-band record changes heal on their own.
+func (c *Companion) RunReconciler(ctx context.Context, logger *Logger) {
+	interval := time.Duration(c.cfg.ReconcileIntervalSecs) * time.Second
+	runEvery(ctx, interval, func() {
+		runWithRecover(logger, "reconciler", func() {
+			c.reconcile(ctx, logger)
+		})
+	})
+}
+
+func (c *Companion) reconcile(ctx context.Context, logger *Logger) {
+	mappings, err := c.GetInitialMappings(ctx, logger)
+	if err != nil {
+		logger.Errorf("reconcile failed to get mappings: %v", err)
+		return
+	}
+	// Check every host against Cloudflare instead of skipping the ones
+	// already synced. Their synced entries are kept, so STATE_FILE is only
+	// written for hosts whose source changed.
+	logSyncResult(logger, "Reconcile", c.SyncMappings(withVerifyAll(ctx), mappings, logger))
+	c.checkSyncedDomainMatches(logger)
+}
+
+type verifyAllKey struct{}
+
+// withVerifyAll makes the syncs of ctx verify the hosts already synced.
+func withVerifyAll(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verifyAllKey{}, true)
+}
+
+func verifyAll(ctx context.Context) bool {
+	verify, _ := ctx.Value(verifyAllKey{}).(bool)
+	return verify
+}
+
+// runEvery calls fn every interval until ctx is cancelled. The next run is
+// scheduled only once fn returns, so a slow run never overlaps the next one.
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			fn()
+			timer.Reset(interval)
+		}
+	}
+}
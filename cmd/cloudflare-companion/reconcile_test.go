@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileResyncsSyncedHosts(t *testing.T) {
+	var created atomic.Int32
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			created.Add(1)
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	comp := &Companion{
+		cfg: Config{
+			EnableDockerPoll:        true,
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		docker: &fakeDocker{containers: []container.InspectResponse{newContainer("c1", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"})}},
+		synced: map[string]int{"a.example.com": 1},
+		plan:   NewPlan(),
+	}
+
+	// The record is gone from Cloudflare although the host is marked synced.
+	comp.reconcile(context.Background(), NewLogger("ERROR"))
+	require.Equal(t, int32(1), created.Load())
+	require.Equal(t, map[string]int{"a.example.com": 1}, comp.synced)
+}
+
+func TestRunEveryDoesNotOverlap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var running, overlaps, runs atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runEvery(ctx, 5*time.Millisecond, func() {
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			if runs.Add(1) == 3 {
+				cancel()
+			}
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runEvery did not stop after cancel")
+	}
+	require.Equal(t, int32(3), runs.Load())
+	require.Zero(t, overlaps.Load())
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+const stateFileVersion = 1
+
+// syncState is the STATE_FILE content. Config fingerprints the settings that
+// decide record content, so a state written for another configuration is not
+// trusted.
+type syncState struct {
+	Version int            `json:"version"`
+	Config  string         `json:"config"`
+	Synced  map[string]int `json:"synced"`
+}
+
+// stateFingerprint hashes the configuration that shapes written records.
+func stateFingerprint(cfg Config) string {
+	h := sha256.New()
+	for _, dom := range cfg.Domains {
+		fmt.Fprintf(h, "%s|%s|%s|%s|%v|%d|%s|%s|%s|%d\n", dom.Name, dom.ZoneID, dom.RecordType, dom.TargetDomain,
+			dom.Proxied, dom.TTL, dom.Comment, dom.ApexRecordType, dom.ApexTargetDomain, dom.MXPriority)
+	}
+	fmt.Fprintf(h, "%v|%v|%v|%v|%v|%s\n", cfg.RecordTags, cfg.RefreshEntries, cfg.CustomHostnames, cfg.PreserveExistingProxied, cfg.FixProxiedDrift, cfg.TargetTemplate)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadState reads the synced hosts from path. A missing file yields an empty
+// map; a state written for a different configuration is rejected.
+func loadState(path string, fingerprint string) (map[string]int, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state syncState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if state.Version != stateFileVersion {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, state.Version)
+	}
+	for host, source := range state.Synced {
+		if host == "" || source <= 0 {
+			return nil, fmt.Errorf("state file %s has an invalid entry %q: %d", path, host, source)
+		}
+	}
+	if state.Config != fingerprint {
+		return nil, fmt.Errorf("state file %s was written for a different configuration", path)
+	}
+	if state.Synced == nil {
+		state.Synced = map[string]int{}
+	}
+	return state.Synced, nil
+}
+
+// writeState replaces path with the synced hosts through a temporary file
+// and a rename, so readers never see a partial file.
+func writeState(path string, fingerprint string, synced map[string]int) error {
+	raw, err := json.Marshal(syncState{Version: stateFileVersion, Config: fingerprint, Synced: synced})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveState persists synced to STATE_FILE. It copies synced under syncedM
+// and writes the copy once the lock is released, so host syncs do not wait
+// on the disk. stateM serializes the writes, skipping a copy older than the
+// one already written.
+func (c *Companion) saveState(logger *Logger) {
+	if c.cfg.StateFile == "" || c.cfg.DryRun {
+		return
+	}
+	c.syncedM.Lock()
+	c.stateVersion++
+	version := c.stateVersion
+	synced := maps.Clone(c.synced)
+	c.syncedM.Unlock()
+
+	c.stateM.Lock()
+	defer c.stateM.Unlock()
+	if version <= c.stateWritten {
+		return
+	}
+	if err := writeState(c.cfg.StateFile, stateFingerprint(c.cfg), synced); err != nil {
+		logger.Warnf("failed to write state file %s: %v", c.cfg.StateFile, err)
+		return
+	}
+	c.stateWritten = version
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	synced, err := loadState(path, "fp")
+	require.NoError(t, err)
+	require.Empty(t, synced)
+
+	require.NoError(t, writeState(path, "fp", map[string]int{"a.example.com": 1, "b.example.com": 2}))
+	synced, err = loadState(path, "fp")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a.example.com": 1, "b.example.com": 2}, synced)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = loadState(path, "other")
+	require.ErrorContains(t, err, "written for a different configuration")
+
+	for _, raw := range []string{`not json`, `{"version":2,"config":"fp"}`, `{"version":1,"config":"fp","synced":{"a.example.com":0}}`} {
+		require.NoError(t, os.WriteFile(path, []byte(raw), 0o600))
+		_, err = loadState(path, "fp")
+		require.Error(t, err, raw)
+	}
+}
+
+func TestStateFingerprint(t *testing.T) {
+	cfg := Config{Domains: []DomainConfig{{Name: "example.com", ZoneID: "zone", TargetDomain: "lb.example.net"}}}
+	fp := stateFingerprint(cfg)
+	require.Equal(t, fp, stateFingerprint(cfg))
+	cfg.Domains[0].TargetDomain = "lb2.example.net"
+	require.NotEqual(t, fp, stateFingerprint(cfg))
+}
+
+func TestSyncPersistsState(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net"}]}`))
+	})
+	path := filepath.Join(t.TempDir(), "state.json")
+	comp := &Companion{
+		cfg: Config{
+			StateFile: path,
+			Domains:   []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+		},
+		cf:     cf,
+		synced: map[string]int{},
+		plan:   NewPlan(),
+	}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+
+	synced, err := loadState(path, stateFingerprint(comp.cfg))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a.example.com": 1}, synced)
+
+	// Dry runs never persist what they would have synced.
+	require.NoError(t, os.Remove(path))
+	comp.cfg.DryRun = true
+	comp.synced = map[string]int{}
+	comp.SyncMappings(context.Background(), map[string]Mapping{"b.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.NoFileExists(t, path)
+}
+
+func TestSaveStateWritesWithoutHoldingSynced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	comp := &Companion{cfg: Config{StateFile: path}, synced: map[string]int{"a.example.com": 1}}
+
+	// While a write is pending, host syncs can still update synced.
+	comp.stateM.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		comp.saveState(NewLogger("ERROR"))
+	}()
+	require.Eventually(t, func() bool {
+		comp.syncedM.Lock()
+		defer comp.syncedM.Unlock()
+		return comp.stateVersion == 1
+	}, time.Second, time.Millisecond)
+	comp.syncedM.Lock()
+	comp.synced["b.example.com"] = 2
+	comp.syncedM.Unlock()
+	comp.stateM.Unlock()
+	<-done
+
+	synced, err := loadState(path, stateFingerprint(comp.cfg))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a.example.com": 1}, synced)
+
+	comp.saveState(NewLogger("ERROR"))
+	synced, err = loadState(path, stateFingerprint(comp.cfg))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"a.example.com": 1, "b.example.com": 2}, synced)
+}
+
+func TestReconcileWritesStateOnlyForChangedHosts(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":[{"id":"rec","type":"CNAME","content":"lb.example.net","ttl":1}]}`))
+	})
+	path := filepath.Join(t.TempDir(), "state.json")
+	comp := &Companion{
+		cfg: Config{
+			EnableDockerPoll:        true,
+			TraefikVersion:          "2",
+			TraefikExposedByDefault: true,
+			IncludedHosts:           matchAll,
+			StateFile:               path,
+			Domains:                 []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", TTL: 1}},
+		},
+		cf:     cf,
+		docker: &fakeDocker{containers: []container.InspectResponse{newContainer("c1", map[string]string{"traefik.http.routers.a.rule": "Host(`a.example.com`)"})}},
+		synced: map[string]int{"a.example.com": 1},
+		plan:   NewPlan(),
+	}
+
+	comp.reconcile(context.Background(), NewLogger("ERROR"))
+	require.Equal(t, map[string]int{"a.example.com": 1}, comp.synced)
+	require.NoFileExists(t, path)
+}
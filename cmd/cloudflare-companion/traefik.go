@@ -5,36 +5,309 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type TraefikRouter struct {
-	Name   string `json:"name"`
-	Rule   string `json:"rule"`
-	Status string `json:"status"`
+	Name        string   `json:"name"`
+	Rule        string   `json:"rule"`
+	Status      string   `json:"status"`
+	Service     string   `json:"service"`
+	Provider    string   `json:"provider"`
+	EntryPoints []string `json:"entryPoints"`
+	Middlewares []string `json:"middlewares"`
+	Priority    int      `json:"priority"`
+	// Detail holds every field of the router detail response, including
+	// ones unknown to Traefik itself, for TRAEFIK_PROXIED_ANNOTATION.
+	Detail map[string]any `json:"-"`
 }
 
-func FetchTraefikRouters(ctx context.Context, baseURL string, insecureSkipVerify bool, caCertFile string) ([]TraefikRouter, int, string, error) {
-	tlsCfg, err := newTLSConfig(caCertFile, insecureSkipVerify)
+const maxTraefikRedirects = 5
+
+// traefikSocketBase is the placeholder base URL of requests sent to Traefik
+// over a unix socket, as only the socket path is needed to connect.
+const traefikSocketBase = "http://traefik"
+
+// TraefikClientOptions configures the requests sent to the Traefik API.
+type TraefikClientOptions struct {
+	// Headers are sent with every request, but dropped on redirects to
+	// another host.
+	Headers            http.Header
+	InsecureSkipVerify bool
+	CACertFile         string
+	// Client, when set, sends the requests and keeps its connections alive
+	// between them. Otherwise each request uses a client of its own.
+	Client *http.Client
+}
+
+// traefikIdleConnTimeout closes the kept alive connections to Traefik that
+// outlive a poll interval.
+const traefikIdleConnTimeout = 90 * time.Second
+
+// newTraefikHTTPClient returns a client for the Traefik API at baseURL,
+// connecting to the socket of a unix:// URL.
+func newTraefikHTTPClient(baseURL string, opts TraefikClientOptions) (*http.Client, error) {
+	tlsCfg, err := newTLSConfig(opts.CACertFile, "", "", opts.InsecureSkipVerify)
 	if err != nil {
-		return nil, 0, "", err
+		return nil, err
+	}
+	transport := &http.Transport{
+		TLSClientConfig: tlsCfg,
+		IdleConnTimeout: traefikIdleConnTimeout,
+	}
+	if socket := traefikSocket(baseURL); socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
 	}
-	httpClient := &http.Client{
-		Timeout: 15 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsCfg,
+	return &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxTraefikRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxTraefikRedirects)
+			}
+			// Go resends custom headers on redirects, so keep the
+			// TRAEFIK_POLL_HEADER_* secrets from reaching another host.
+			if req.URL.Hostname() != via[0].URL.Hostname() {
+				for name := range opts.Headers {
+					req.Header.Del(name)
+				}
+			}
+			return nil
 		},
+	}, nil
+}
+
+type TraefikService struct {
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+	LoadBalancer *struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	} `json:"loadBalancer"`
+}
+
+const traefikPollHeaderPrefix = "TRAEFIK_POLL_HEADER_"
+
+// traefikPollHeaders collects the TRAEFIK_POLL_HEADER_<NAME> variables, or
+// their _FILE secrets, as headers sent to the Traefik API. Underscores in
+// the name become dashes, so TRAEFIK_POLL_HEADER_CF_ACCESS_CLIENT_ID sets
+// CF-Access-Client-Id.
+func traefikPollHeaders() http.Header {
+	headers := http.Header{}
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		name, ok := strings.CutPrefix(strings.ToUpper(key), traefikPollHeaderPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		name = strings.TrimSuffix(name, "_FILE")
+		if value := getSecretByEnv(traefikPollHeaderPrefix + name); value != "" {
+			headers.Set(strings.ReplaceAll(name, "_", "-"), value)
+		}
+	}
+	return headers
+}
+
+func setHeaders(req *http.Request, headers http.Header) {
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+}
+
+// traefikSocket returns the socket path of a unix:// TRAEFIK_POLL_URL, or ""
+// for other URLs.
+func traefikSocket(base string) string {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme != "unix" {
+		return ""
+	}
+	return u.Path
+}
+
+// traefikAPIURL joins the Traefik base URL with its API path, "/api" unless
+// the API is exposed elsewhere.
+func traefikAPIURL(base string, apiPath string) string {
+	if traefikSocket(base) != "" {
+		base = traefikSocketBase
+	}
+	apiPath = strings.Trim(defaultString(apiPath, "/api"), "/")
+	if apiPath == "" {
+		return strings.TrimRight(base, "/")
+	}
+	return strings.TrimRight(base, "/") + "/" + apiPath
+}
+
+func FetchTraefikRouter(ctx context.Context, baseURL string, apiPath string, name string, opts TraefikClientOptions) (TraefikRouter, error) {
+	var raw json.RawMessage
+	var router TraefikRouter
+	if err := fetchTraefikObject(ctx, baseURL, apiPath, "/http/routers/"+url.PathEscape(name), opts, &raw); err != nil {
+		return router, err
+	}
+	if err := json.Unmarshal(raw, &router); err != nil {
+		return router, fmt.Errorf("failed to decode JSON from Traefik: %w", err)
+	}
+	if err := json.Unmarshal(raw, &router.Detail); err != nil {
+		return router, fmt.Errorf("failed to decode JSON from Traefik: %w", err)
+	}
+	return router, nil
+}
+
+// routerAnnotation looks up a TRAEFIK_PROXIED_ANNOTATION key in the router
+// detail, either as a top level field or as a dotted path into nested
+// objects whose keys may contain dots themselves, and parses it as a boolean.
+func routerAnnotation(detail map[string]any, key string) (bool, bool, error) {
+	value, ok := lookupDotted(detail, key)
+	if !ok {
+		return false, false, nil
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, true, nil
+	case string:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, false, fmt.Errorf("%s is %q, not a boolean", key, v)
+		}
+		return parsed, true, nil
+	}
+	return false, false, fmt.Errorf("%s is %v, not a boolean", key, value)
+}
+
+func lookupDotted(obj map[string]any, key string) (any, bool) {
+	if value, ok := obj[key]; ok {
+		return value, true
+	}
+	for i := range len(key) {
+		if key[i] != '.' {
+			continue
+		}
+		child, ok := obj[key[:i]].(map[string]any)
+		if !ok {
+			continue
+		}
+		if value, ok := lookupDotted(child, key[i+1:]); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func FetchTraefikService(ctx context.Context, baseURL string, apiPath string, name string, opts TraefikClientOptions) (TraefikService, error) {
+	var service TraefikService
+	err := fetchTraefikObject(ctx, baseURL, apiPath, "/http/services/"+url.PathEscape(name), opts, &service)
+	return service, err
+}
+
+func fetchTraefikObject(ctx context.Context, baseURL string, apiPath string, path string, opts TraefikClientOptions, out any) error {
+	statusCode, bodyBytes, err := getTraefik(ctx, baseURL, apiPath, path, opts)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("traefik API returned error %d: %s", statusCode, string(bodyBytes))
+	}
+	if err := json.Unmarshal(bodyBytes, out); err != nil {
+		return fmt.Errorf("failed to decode JSON from Traefik: %w", err)
+	}
+	return nil
+}
+
+// serviceTarget returns the host of the only load balancer server of a
+// service, or "" when the service has no or several servers.
+func serviceTarget(service TraefikService) string {
+	if service.LoadBalancer == nil || len(service.LoadBalancer.Servers) != 1 {
+		return ""
+	}
+	parsed, err := url.Parse(service.LoadBalancer.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// routerProvider returns the provider suffix of a router name, for example
+// "file" for "my-router@file", or "" when the name has none.
+func routerProvider(name string) string {
+	_, provider, ok := strings.Cut(name, "@")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(provider)
+}
+
+// parseProviderFilter parses a comma-separated TRAEFIK_PROVIDER_FILTER,
+// accepting providers with or without the leading "@".
+func parseProviderFilter(raw string) []string {
+	providers := splitCleanCSV(strings.ToLower(raw))
+	for i, provider := range providers {
+		providers[i] = strings.TrimPrefix(provider, "@")
+	}
+	return providers
+}
+
+// routerServiceName qualifies the router's service with the router provider,
+// as services are addressed as "name@provider" in the Traefik API.
+func routerServiceName(router TraefikRouter) string {
+	if router.Service == "" || strings.Contains(router.Service, "@") || router.Provider == "" {
+		return router.Service
+	}
+	return router.Service + "@" + router.Provider
+}
+
+func FetchTraefikRouters(ctx context.Context, baseURL string, insecureSkipVerify bool, caCertFile string) ([]TraefikRouter, int, string, error) {
+	return FetchTraefikRoutersWithOptions(ctx, baseURL, "", TraefikClientOptions{InsecureSkipVerify: insecureSkipVerify, CACertFile: caCertFile})
+}
+
+// FetchTraefikRoutersWithOptions lists the HTTP routers of the Traefik API
+// served under apiPath ("/api" when empty) of baseURL.
+func FetchTraefikRoutersWithOptions(ctx context.Context, baseURL string, apiPath string, opts TraefikClientOptions) ([]TraefikRouter, int, string, error) {
+	statusCode, bodyBytes, err := getTraefik(ctx, baseURL, apiPath, "/http/routers", opts)
+	if err != nil {
+		return nil, statusCode, "", err
+	}
+	body := string(bodyBytes)
+	if statusCode != http.StatusOK {
+		return nil, statusCode, body, nil
+	}
+
+	var routers []TraefikRouter
+	if err := json.Unmarshal(bodyBytes, &routers); err != nil {
+		return nil, statusCode, body, fmt.Errorf("failed to decode JSON from Traefik: %w", err)
 	}
-	url := strings.TrimRight(baseURL, "/") + "/api/http/routers"
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return routers, statusCode, body, nil
+}
+
+// getTraefik sends a GET for path of the Traefik API and returns the status
+// code and body of the response.
+func getTraefik(ctx context.Context, baseURL string, apiPath string, path string, opts TraefikClientOptions) (int, []byte, error) {
+	httpClient := opts.Client
+	if httpClient == nil {
+		var err error
+		httpClient, err = newTraefikHTTPClient(baseURL, opts)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer httpClient.CloseIdleConnections()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, traefikAPIURL(baseURL, apiPath)+path, nil)
 	if err != nil {
-		return nil, 0, "", err
+		return 0, nil, err
 	}
+	setHeaders(req, opts.Headers)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, 0, "", err
+		return 0, nil, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
@@ -42,16 +315,36 @@ func FetchTraefikRouters(ctx context.Context, baseURL string, insecureSkipVerify
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, resp.StatusCode, "", err
-	}
-	body := string(bodyBytes)
-	if resp.StatusCode != http.StatusOK {
-		return nil, resp.StatusCode, body, nil
+		return resp.StatusCode, nil, err
 	}
+	return resp.StatusCode, bodyBytes, nil
+}
 
-	var routers []TraefikRouter
-	if err := json.Unmarshal(bodyBytes, &routers); err != nil {
-		return nil, resp.StatusCode, body, fmt.Errorf("failed to decode JSON from Traefik: %w", err)
+var routerTTLToken = regexp.MustCompile(`^ttl([0-9]+)$`)
+
+// routerOverrides derives per-host record overrides from the router and
+// service names. A "-proxied" or "-dnsonly" token forces the proxied flag and
+// a "-ttl<seconds>" token sets the TTL, e.g. "app-proxied-ttl300@docker".
+// Tokens on the router name take precedence over tokens on the service name.
+func routerOverrides(router TraefikRouter) Mapping {
+	mapping := Mapping{}
+	for _, name := range []string{router.Service, router.Name} {
+		name = strings.SplitN(name, "@", 2)[0]
+		for _, token := range strings.Split(strings.ToLower(name), "-") {
+			switch {
+			case token == "proxied":
+				proxied := true
+				mapping.Proxied = &proxied
+			case token == "dnsonly":
+				proxied := false
+				mapping.Proxied = &proxied
+			case routerTTLToken.MatchString(token):
+				ttl, err := strconv.Atoi(routerTTLToken.FindStringSubmatch(token)[1])
+				if err == nil {
+					mapping.TTL = &ttl
+				}
+			}
+		}
 	}
-	return routers, resp.StatusCode, body, nil
+	return mapping
 }
@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -38,3 +44,275 @@ func TestFetchTraefikRoutersErrorPage(t *testing.T) {
 	require.Equal(t, "<html>bad gateway</html>", body)
 	require.Nil(t, routers)
 }
+
+func TestFetchTraefikRouterDetail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/http/routers/app@docker", r.URL.Path)
+		_, _ = w.Write([]byte(`{"name":"app@docker","rule":"Host(` + "`app.example.com`" + `)","status":"enabled","service":"app-proxied","provider":"docker"}`))
+	}))
+	defer ts.Close()
+
+	router, err := FetchTraefikRouter(context.Background(), ts.URL, "", "app@docker", TraefikClientOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "app-proxied", router.Service)
+	require.Equal(t, "docker", router.Provider)
+	require.Equal(t, "docker", router.Detail["provider"])
+}
+
+func TestFetchTraefikRoutersSendsHeaders(t *testing.T) {
+	t.Setenv("TRAEFIK_POLL_HEADER_CF_ACCESS_CLIENT_ID", "client-id")
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("client-secret\n"), 0o600))
+	t.Setenv("TRAEFIK_POLL_HEADER_CF_ACCESS_CLIENT_SECRET_FILE", secret)
+	headers := traefikPollHeaders()
+	require.Equal(t, "client-id", headers.Get("CF-Access-Client-Id"))
+	require.Equal(t, "client-secret", headers.Get("CF-Access-Client-Secret"))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "client-id", r.Header.Get("CF-Access-Client-Id"))
+		require.Equal(t, "client-secret", r.Header.Get("CF-Access-Client-Secret"))
+		router := `{"name":"app@docker","rule":"Host(` + "`app.example.com`" + `)","status":"enabled"}`
+		if r.URL.Path == "/api/http/routers" {
+			router = "[" + router + "]"
+		}
+		_, _ = w.Write([]byte(router))
+	}))
+	defer ts.Close()
+
+	routers, _, _, err := FetchTraefikRoutersWithOptions(context.Background(), ts.URL, "", TraefikClientOptions{Headers: headers})
+	require.NoError(t, err)
+	require.Len(t, routers, 1)
+	_, err = FetchTraefikRouter(context.Background(), ts.URL, "", "app@docker", TraefikClientOptions{Headers: headers})
+	require.NoError(t, err)
+}
+
+func TestTraefikPollUserAgent(t *testing.T) {
+	t.Setenv("CF_TOKEN", "token")
+	t.Setenv("TARGET_DOMAIN", "lb.example.net")
+	t.Setenv("DOMAIN1", "example.com")
+	t.Setenv("DOMAIN1_ZONE_ID", "zone1")
+
+	cfg, err := LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, defaultUserAgent(), cfg.UserAgent)
+	require.Equal(t, "docker-traefik-cloudflare-gompanion/dev", cfg.TraefikPollHeaders.Get("User-Agent"))
+
+	t.Setenv("HTTP_USER_AGENT", "custom/1.0")
+	cfg, err = LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "custom/1.0", cfg.TraefikPollHeaders.Get("User-Agent"))
+
+	t.Setenv("TRAEFIK_POLL_HEADER_USER_AGENT", "traefik-only/1.0")
+	cfg, err = LoadConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "custom/1.0", cfg.UserAgent)
+	require.Equal(t, "traefik-only/1.0", cfg.TraefikPollHeaders.Get("User-Agent"))
+}
+
+func TestRouterAnnotation(t *testing.T) {
+	detail := map[string]any{
+		"cloudflare.proxied": "true",
+		"metadata":           map[string]any{"proxied": false, "ttl": "300"},
+	}
+	proxied, ok, err := routerAnnotation(detail, "cloudflare.proxied")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, proxied)
+
+	proxied, ok, err = routerAnnotation(detail, "metadata.proxied")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, proxied)
+
+	_, ok, err = routerAnnotation(detail, "metadata.missing")
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = routerAnnotation(nil, "proxied")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = routerAnnotation(detail, "metadata.ttl")
+	require.EqualError(t, err, `metadata.ttl is "300", not a boolean`)
+}
+
+func TestRouterOverrides(t *testing.T) {
+	overrides := routerOverrides(TraefikRouter{Name: "app-dnsonly-ttl300@docker", Service: "app-proxied"})
+	require.NotNil(t, overrides.Proxied)
+	require.False(t, *overrides.Proxied)
+	require.NotNil(t, overrides.TTL)
+	require.Equal(t, 300, *overrides.TTL)
+
+	overrides = routerOverrides(TraefikRouter{Name: "app@docker", Service: "app-proxied@docker"})
+	require.True(t, *overrides.Proxied)
+	require.Nil(t, overrides.TTL)
+
+	require.Equal(t, Mapping{}, routerOverrides(TraefikRouter{Name: "app@docker", Service: "app"}))
+}
+
+func TestCheckTraefikFetchesDetailsAfterHostFilters(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte(`[
+				{"name":"internal@docker","rule":"Host(` + "`internal.example.com`" + `)","status":"enabled","service":"internal"},
+				{"name":"many@docker","rule":"Host(` + "`internal.lan.example.com`" + `) || Host(` + "`a.example.com`" + `) || Host(` + "`b.example.com`" + `) || Host(` + "`c.example.com`" + `)","status":"enabled","service":"many"}
+			]`))
+		default:
+			mu.Lock()
+			fetched = append(fetched, r.URL.Path)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"name":"many@docker","loadBalancer":{"servers":[{"url":"http://192.0.2.20"}]}}`))
+		}
+	}))
+	defer ts.Close()
+
+	comp := &Companion{cfg: Config{
+		TraefikPollURL:          ts.URL,
+		TraefikRouterOverrides:  true,
+		TraefikUseServiceTarget: true,
+		IncludedHosts:           matchAll,
+		ExcludedHosts:           []*regexp.Regexp{regexp.MustCompile(`^internal\.`)},
+		MaxHostsPerSource:       2,
+	}}
+	mappings, ok := comp.checkTraefik(context.Background(), NewLogger("ERROR"))
+	require.True(t, ok)
+	// The limit counts the hosts left after the filters, and the router
+	// without any is not fetched.
+	require.Equal(t, map[string]Mapping{
+		"a.example.com": {Source: 2, Target: "192.0.2.20"},
+		"b.example.com": {Source: 2, Target: "192.0.2.20"},
+	}, mappings)
+	require.Equal(t, []string{"/api/http/routers/many@docker", "/api/http/services/many"}, fetched)
+}
+
+func TestFetchTraefikRoutersFollowsSchemeRedirect(t *testing.T) {
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/http/routers", r.URL.Path)
+		_, _ = w.Write([]byte(`[{"name":"app@docker","rule":"Host(` + "`app.example.com`" + `)","status":"enabled"}]`))
+	}))
+	defer secure.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, secure.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer plain.Close()
+
+	routers, status, _, err := FetchTraefikRouters(context.Background(), plain.URL, true, "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, routers, 1)
+}
+
+func TestFetchTraefikRoutersDropsHeadersOnForeignRedirect(t *testing.T) {
+	var received http.Header
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer foreign.Close()
+	_, port, err := net.SplitHostPort(foreign.Listener.Addr().String())
+	require.NoError(t, err)
+	redirectTo := foreign.URL
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, redirectTo+r.URL.Path, http.StatusFound)
+	}))
+	defer origin.Close()
+	headers := http.Header{"Cf-Access-Client-Secret": {"client-secret"}}
+
+	_, _, _, err = FetchTraefikRoutersWithOptions(context.Background(), origin.URL, "", TraefikClientOptions{Headers: headers})
+	require.NoError(t, err)
+	require.Equal(t, "client-secret", received.Get("CF-Access-Client-Secret"))
+
+	received = nil
+	redirectTo = "http://localhost:" + port
+	_, _, _, err = FetchTraefikRoutersWithOptions(context.Background(), origin.URL, "", TraefikClientOptions{Headers: headers})
+	require.NoError(t, err)
+	require.NotNil(t, received)
+	require.Empty(t, received.Get("CF-Access-Client-Secret"))
+}
+
+func TestFetchTraefikRoutersRedirectLoop(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	_, _, _, err := FetchTraefikRouters(context.Background(), ts.URL, false, "")
+	require.ErrorContains(t, err, "stopped after 5 redirects")
+}
+
+func TestTraefikClientReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	httpClient, err := newTraefikHTTPClient(ts.URL, TraefikClientOptions{})
+	require.NoError(t, err)
+	for range 3 {
+		_, _, _, err := FetchTraefikRoutersWithOptions(context.Background(), ts.URL, "", TraefikClientOptions{Client: httpClient})
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 1, conns.Load())
+}
+
+func TestTraefikAPIURL(t *testing.T) {
+	require.Equal(t, "http://traefik:8080/api", traefikAPIURL("http://traefik:8080", ""))
+	require.Equal(t, "http://traefik:8080/api", traefikAPIURL("http://traefik:8080/", "/api"))
+	require.Equal(t, "https://proxy/traefik/api", traefikAPIURL("https://proxy", "/traefik/api/"))
+	require.Equal(t, "https://proxy/traefik", traefikAPIURL("https://proxy/traefik", "/"))
+}
+
+func TestRouterProviderFilter(t *testing.T) {
+	require.Equal(t, "file", routerProvider("my-router@file"))
+	require.Equal(t, "kubernetescrd", routerProvider("ns-app@KubernetesCRD"))
+	require.Equal(t, "", routerProvider("my-router"))
+	require.Equal(t, []string{"file", "docker"}, parseProviderFilter(" @file, Docker ,"))
+	require.Empty(t, parseProviderFilter(""))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name":"a@file","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"},
+			{"name":"b@docker","rule":"Host(` + "`b.example.com`" + `)","status":"enabled"},
+			{"name":"c","rule":"Host(` + "`c.example.com`" + `)","status":"enabled"}
+		]`))
+	}))
+	defer ts.Close()
+
+	comp := &Companion{cfg: Config{TraefikPollURL: ts.URL, IncludedHosts: matchAll, TraefikProviderFilter: []string{"file"}}}
+	mappings, ok := comp.checkTraefik(context.Background(), NewLogger("ERROR"))
+	require.True(t, ok)
+	require.Equal(t, map[string]Mapping{"a.example.com": {Source: 2}}, mappings)
+}
+
+func TestFetchTraefikRoutersUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "traefik.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/http/routers", r.URL.Path)
+		_, _ = w.Write([]byte(`[{"name":"app@docker","rule":"Host(` + "`a.example.com`" + `)","status":"enabled"}]`))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer func() { _ = server.Close() }()
+
+	base := "unix://" + socket
+	require.Equal(t, socket, traefikSocket(base))
+	require.Empty(t, traefikSocket("http://traefik:8080"))
+	require.Equal(t, "http://traefik/api", traefikAPIURL(base, "/api"))
+
+	routers, status, _, err := FetchTraefikRoutersWithOptions(context.Background(), base, "/api", TraefikClientOptions{})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, routers, 1)
+	require.Equal(t, "app@docker", routers[0].Name)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{Version: version, Commit: commit, Date: date}
+}
+
+// defaultUserAgent identifies outbound requests unless HTTP_USER_AGENT
+// overrides it.
+func defaultUserAgent() string {
+	return "docker-traefik-cloudflare-gompanion/" + version
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", b.Version, b.Commit, b.Date)
+}
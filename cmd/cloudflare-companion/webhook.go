@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type WebhookEvent struct {
+	Action   string `json:"action"`
+	Hostname string `json:"hostname"`
+	Zone     string `json:"zone"`
+	Content  string `json:"content"`
+	Proxied  bool   `json:"proxied"`
+}
+
+type Webhook struct {
+	url        string
+	userAgent  string
+	httpClient *http.Client
+}
+
+func NewWebhook(url string, userAgent string) *Webhook {
+	return &Webhook{
+		url:        url,
+		userAgent:  userAgent,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *Webhook) Notify(ctx context.Context, event WebhookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.userAgent != "" {
+		req.Header.Set("User-Agent", w.userAgent)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return nil
+}
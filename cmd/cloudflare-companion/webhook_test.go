@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPointDomainNotifiesWebhook(t *testing.T) {
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"success":true,"result":{"id":"rec"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":[]}`))
+	})
+	var events []WebhookEvent
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events = append(events, event)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hook.Close()
+
+	comp := &Companion{
+		cfg: Config{
+			Domains: []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net", Proxied: true}},
+		},
+		cf:      cf,
+		synced:  map[string]int{},
+		webhook: NewWebhook(hook.URL, ""),
+	}
+
+	comp.SyncMappings(context.Background(), map[string]Mapping{"a.example.com": {Source: 1}}, NewLogger("ERROR"))
+	require.Equal(t, []WebhookEvent{{Action: "create", Hostname: "a.example.com", Zone: "zone", Content: "lb.example.net", Proxied: true}}, events)
+	require.Equal(t, map[string]int{"a.example.com": 1}, comp.synced)
+}
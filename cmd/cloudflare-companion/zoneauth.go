@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// isZoneAuthError reports whether err is Cloudflare refusing the token
+// access to a zone it is otherwise valid for.
+func isZoneAuthError(err error) bool {
+	var cfErr *CloudflareError
+	return errors.As(err, &cfErr) && cfErr.StatusCode == http.StatusForbidden
+}
+
+// disableZoneOnAuthError stops syncing dom's zone when err shows the token is
+// not authorized for it, for ZONE_AUTH_COOLDOWN_SECONDS or, when that is 0,
+// the rest of the run. It reports whether the zone was disabled.
+func (c *Companion) disableZoneOnAuthError(dom DomainConfig, err error, logger *Logger) bool {
+	if !isZoneAuthError(err) {
+		return false
+	}
+	until := time.Time{}
+	if c.cfg.ZoneAuthCooldownSecs > 0 {
+		until = time.Now().Add(time.Duration(c.cfg.ZoneAuthCooldownSecs) * time.Second)
+	}
+	c.disabledZonesM.Lock()
+	defer c.disabledZonesM.Unlock()
+	if _, disabled := c.disabledZones[dom.ZoneID]; disabled {
+		return true
+	}
+	if c.disabledZones == nil {
+		c.disabledZones = map[string]time.Time{}
+	}
+	c.disabledZones[dom.ZoneID] = until
+	if until.IsZero() {
+		logger.Warnf("Cloudflare token is not authorized for zone %s of %s, skipping the domain for the rest of the run", dom.ZoneID, dom.Name)
+	} else {
+		logger.Warnf("Cloudflare token is not authorized for zone %s of %s, skipping the domain until %s", dom.ZoneID, dom.Name, until.Format(time.RFC3339))
+	}
+	return true
+}
+
+// zoneDisabled reports whether zoneID is disabled after an authorization
+// failure, re-enabling it once its cooldown passed.
+func (c *Companion) zoneDisabled(zoneID string) bool {
+	c.disabledZonesM.Lock()
+	defer c.disabledZonesM.Unlock()
+	until, disabled := c.disabledZones[zoneID]
+	if !disabled {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(c.disabledZones, zoneID)
+		return false
+	}
+	return true
+}
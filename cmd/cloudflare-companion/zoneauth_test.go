@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneAuthErrorDisablesZone(t *testing.T) {
+	var requests int
+	cf := newTestCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":9109,"message":"Unauthorized to access requested resource"}]}`))
+	})
+	comp := &Companion{cfg: Config{
+		ZoneAuthCooldownSecs: 60,
+		Domains:              []DomainConfig{{Name: "example.com", RecordType: "CNAME", ZoneID: "zone", TargetDomain: "lb.example.net"}},
+	}, cf: cf}
+	buf := &bytes.Buffer{}
+	logger := newBufferLogger(buf)
+
+	require.False(t, comp.pointDomain(context.Background(), "a.example.com", Mapping{Source: 1}, &SyncResult{}, logger))
+	seen := requests
+	require.NotZero(t, seen)
+	require.True(t, comp.zoneDisabled("zone"))
+
+	res := &SyncResult{}
+	require.False(t, comp.pointDomain(context.Background(), "b.example.com", Mapping{Source: 1}, res, logger))
+	require.Equal(t, seen, requests)
+	require.Equal(t, SyncResult{Skipped: 1}, *res)
+	require.Equal(t, 1, strings.Count(buf.String(), "Cloudflare token is not authorized for zone zone of example.com, skipping the domain until"))
+
+	comp.disabledZones["zone"] = time.Now().Add(-time.Second)
+	require.False(t, comp.zoneDisabled("zone"))
+	require.False(t, comp.pointDomain(context.Background(), "b.example.com", Mapping{Source: 1}, &SyncResult{}, logger))
+	require.Greater(t, requests, seen)
+}
+
+func TestZoneAuthErrorIgnoresOtherErrors(t *testing.T) {
+	comp := &Companion{}
+	dom := DomainConfig{Name: "example.com", ZoneID: "zone"}
+	require.False(t, comp.disableZoneOnAuthError(dom, &CloudflareError{StatusCode: http.StatusTooManyRequests}, NewLogger("ERROR")))
+	require.False(t, comp.zoneDisabled("zone"))
+	require.True(t, comp.disableZoneOnAuthError(dom, &CloudflareError{StatusCode: http.StatusForbidden}, NewLogger("ERROR")))
+	require.True(t, comp.zoneDisabled("zone"))
+}